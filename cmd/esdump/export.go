@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yzlq99/go_utils/utils/es"
+)
+
+// exportProgressInterval 每导出多少文档记录一次进度
+const exportProgressInterval = 10000
+
+type exportOptions struct {
+	index      string
+	queryFile  string
+	format     string
+	fields     []string
+	out        string
+	pageSize   int
+	idField    string
+	pagination string
+}
+
+func runExport(ctx context.Context, args []string) error {
+	var (
+		conn   connectionFlags
+		opts   exportOptions
+		fields string
+	)
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	conn.register(fs)
+	fs.StringVar(&opts.index, "index", "", "index or alias to export (required)")
+	fs.StringVar(&opts.queryFile, "query", "", "file containing the search body, e.g. {\"query\": {...}, \"sort\": [...]}")
+	fs.StringVar(&opts.format, "format", "ndjson", "output format, ndjson or csv")
+	fs.StringVar(&fields, "fields", "", "comma separated fields to export, _id and _index are allowed; required for csv; fields other than _id and _index conflict with _source in the -query file")
+	fs.StringVar(&opts.out, "out", "", "output file, default stdout")
+	fs.IntVar(&opts.pageSize, "page-size", 1000, "documents per page")
+	fs.StringVar(&opts.idField, "id-field", "", "ndjson only, write the document _id into this field")
+	fs.StringVar(&opts.pagination, "pagination", "scroll", "scroll or search_after")
+	_ = fs.Parse(args)
+
+	if opts.index == "" {
+		fs.Usage()
+		return errors.New("-index is required")
+	}
+	if fields != "" {
+		opts.fields = strings.Split(fields, ",")
+	}
+	switch {
+	case opts.format != "ndjson" && opts.format != "csv":
+		return fmt.Errorf("unknown format %q", opts.format)
+	case opts.format == "csv" && len(opts.fields) == 0:
+		return errors.New("-fields is required for csv")
+	}
+	var pagination es.Pagination
+	switch opts.pagination {
+	case "scroll":
+		pagination = es.PaginationScroll
+	case "search_after":
+		pagination = es.PaginationSearchAfter
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown pagination %q", opts.pagination)
+	}
+
+	query, err := exportQuery(opts)
+	if err != nil {
+		return err
+	}
+	esClient, logger, err := conn.connect()
+	if err != nil {
+		return err
+	}
+
+	out := io.Writer(os.Stdout)
+	var f *os.File
+	if opts.out != "" {
+		if f, err = os.Create(opts.out); err != nil {
+			return err
+		}
+		out = f
+	}
+
+	it := es.NewIterator(query, opts.index, esClient, es.WithPageSize(opts.pageSize), es.WithPagination(pagination))
+	defer it.Close()
+
+	count, err := exportHits(ctx, it, out, opts, logger)
+	if f != nil {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	logger.Infof("exported %d documents from %s", count, opts.index)
+	return nil
+}
+
+// exportHits 将遍历到的文档写入 out，返回写入的文档数量。出错时仍会写出已缓冲的内容，返回第一个错误
+func exportHits(ctx context.Context, it es.Iterator, out io.Writer, opts exportOptions, logger *logrus.Logger) (int, error) {
+	buf := bufio.NewWriter(out)
+
+	var (
+		write func(hit es.Hit) error
+		flush = buf.Flush
+	)
+	if opts.format == "csv" {
+		w := csv.NewWriter(buf)
+		write = func(hit es.Hit) error {
+			record, err := csvRecord(hit, opts.fields)
+			if err != nil {
+				return err
+			}
+			return w.Write(record)
+		}
+		flush = func() error {
+			w.Flush()
+			if err := w.Error(); err != nil {
+				return err
+			}
+			return buf.Flush()
+		}
+		if err := w.Write(opts.fields); err != nil {
+			return 0, err
+		}
+	} else {
+		write = func(hit es.Hit) error {
+			line, err := ndjsonLine(hit, opts.idField)
+			if err != nil {
+				return err
+			}
+			_, err = buf.Write(line)
+			return err
+		}
+	}
+
+	count := 0
+	err := func() error {
+		for it.Next(ctx) {
+			if err := write(it.RawHit()); err != nil {
+				return err
+			}
+			count++
+			if count%exportProgressInterval == 0 {
+				logger.Infof("exported %d documents", count)
+			}
+		}
+		return it.Err()
+	}()
+	if flushErr := flush(); err == nil {
+		err = flushErr
+	}
+	return count, err
+}
+
+// exportQuery 读取查询文件，指定了字段时只返回这些字段的 _source
+// 查询文件中已经有 _source 时不能再用 -fields 指定 _source 字段，避免其中一个被静默忽略
+func exportQuery(opts exportOptions) (map[string]interface{}, error) {
+	query := map[string]interface{}{}
+	if opts.queryFile != "" {
+		body, err := ioutil.ReadFile(opts.queryFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(body, &query); err != nil {
+			return nil, fmt.Errorf("invalid query file %s, %v", opts.queryFile, err)
+		}
+	}
+	var includes []string
+	for _, field := range opts.fields {
+		if field != "_id" && field != "_index" {
+			includes = append(includes, field)
+		}
+	}
+	if len(includes) > 0 {
+		if _, ok := query["_source"]; ok {
+			return nil, fmt.Errorf("query file %s already has _source, remove it or the -fields other than _id and _index", opts.queryFile)
+		}
+		if opts.idField != "" {
+			includes = append(includes, opts.idField)
+		}
+		query["_source"] = includes
+	}
+	return query, nil
+}
+
+func ndjsonLine(hit es.Hit, idField string) ([]byte, error) {
+	source := []byte(hit.Source)
+	if idField != "" {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(hit.Source, &doc); err != nil {
+			return nil, fmt.Errorf("decode document %s failed, %v", hit.ID, err)
+		}
+		if _, ok := doc[idField]; !ok {
+			id, _ := json.Marshal(hit.ID)
+			doc[idField] = id
+			var err error
+			if source, err = json.Marshal(doc); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// _source 可能是多行的格式化 JSON
+	var line bytes.Buffer
+	if err := json.Compact(&line, source); err != nil {
+		return nil, fmt.Errorf("invalid document %s, %v", hit.ID, err)
+	}
+	line.WriteByte('\n')
+	return line.Bytes(), nil
+}
+
+func csvRecord(hit es.Hit, fields []string) ([]string, error) {
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(hit.Source))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode document %s failed, %v", hit.ID, err)
+	}
+
+	record := make([]string, len(fields))
+	for i, field := range fields {
+		switch field {
+		case "_id":
+			record[i] = hit.ID
+		case "_index":
+			record[i] = hit.Index
+		default:
+			value, err := csvValue(lookupField(doc, field))
+			if err != nil {
+				return nil, err
+			}
+			record[i] = value
+		}
+	}
+	return record, nil
+}
+
+// csvValue 字符串与数字原样输出，数组、对象输出为 JSON
+func csvValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	}
+	b, err := json.Marshal(value)
+	return string(b), err
+}
+
+// lookupField 按 a.b.c 形式的路径读取字段
+func lookupField(doc map[string]interface{}, path string) interface{} {
+	if value, ok := doc[path]; ok {
+		return value
+	}
+	parts := strings.SplitN(path, ".", 2)
+	if len(parts) < 2 {
+		return nil
+	}
+	child, ok := doc[parts[0]].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return lookupField(child, parts[1])
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/yzlq99/go_utils/utils/es"
+)
+
+// maxReportedFailures 最多记录多少条失败文档的详情
+const maxReportedFailures = 10
+
+type importOptions struct {
+	index     string
+	in        string
+	batchSize int
+	workers   int
+	idField   string
+	action    string
+}
+
+func runImport(ctx context.Context, args []string) error {
+	var (
+		conn connectionFlags
+		opts importOptions
+	)
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	conn.register(fs)
+	fs.StringVar(&opts.index, "index", "", "index or alias to import into (required)")
+	fs.StringVar(&opts.in, "in", "", "NDJSON input file, one document per line, default stdin")
+	fs.IntVar(&opts.batchSize, "batch-size", 1000, "documents per bulk request")
+	fs.IntVar(&opts.workers, "workers", runtime.NumCPU(), "concurrent bulk requests")
+	fs.StringVar(&opts.idField, "id-field", "", "use this field of each document as _id, dotted paths are allowed; empty to let es generate ids")
+	fs.StringVar(&opts.action, "action", "index", "bulk action, index or create")
+	_ = fs.Parse(args)
+
+	if opts.index == "" {
+		fs.Usage()
+		return errors.New("-index is required")
+	}
+	if opts.action != "index" && opts.action != "create" {
+		return fmt.Errorf("unknown action %q", opts.action)
+	}
+
+	in := io.Reader(os.Stdin)
+	if opts.in != "" {
+		f, err := os.Open(opts.in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	esClient, logger, err := conn.connect()
+	if err != nil {
+		return err
+	}
+
+	var (
+		mu       sync.Mutex
+		failures int
+	)
+	onFailure := func(item es.BulkIndexerItem, result es.BulkItemResult, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failures++
+		if failures > maxReportedFailures {
+			return
+		}
+		switch {
+		case err != nil:
+			logger.WithError(err).Warnf("document %s failed", item.DocumentID)
+		case result.Error != nil:
+			logger.Warnf("document %s failed, [%d] %s: %s", result.ID, result.Status, result.Error.Type, result.Error.Reason)
+		default:
+			logger.Warnf("document %s failed, status %d", result.ID, result.Status)
+		}
+	}
+
+	bi, err := es.NewBulkIndexer(es.BulkIndexerConfig{
+		Index:      opts.index,
+		NumWorkers: opts.workers,
+		FlushItems: opts.batchSize,
+	}, esClient)
+	if err != nil {
+		return err
+	}
+
+	count, err := addDocuments(ctx, bufio.NewReader(in), bi, opts, onFailure, func(n int) {
+		logger.Infof("read %d documents", n)
+	})
+	if closeErr := bi.Close(ctx); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	stats := bi.Stats()
+	logger.Infof("imported %d documents into %s, %d failed", stats.NumFlushed, opts.index, stats.NumFailed)
+	if failures > 0 {
+		return fmt.Errorf("%d of %d documents failed", failures, count)
+	}
+	return nil
+}
+
+// addDocuments 逐行读取文档并添加到 BulkIndexer，返回读取的文档数
+func addDocuments(ctx context.Context, r *bufio.Reader, bi *es.BulkIndexer, opts importOptions,
+	onFailure func(es.BulkIndexerItem, es.BulkItemResult, error), onProgress func(int)) (int, error) {
+	count := 0
+	for lineNo := 1; ; lineNo++ {
+		// 文档可能很大，不使用有行长度限制的 bufio.Scanner
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return count, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			item, itemErr := importItem(line, opts)
+			if itemErr != nil {
+				return count, fmt.Errorf("line %d: %v", lineNo, itemErr)
+			}
+			item.OnFailure = onFailure
+			if addErr := bi.Add(ctx, item); addErr != nil {
+				return count, addErr
+			}
+			count++
+			if count%exportProgressInterval == 0 {
+				onProgress(count)
+			}
+		}
+		if err == io.EOF {
+			return count, nil
+		}
+	}
+}
+
+func importItem(line []byte, opts importOptions) (es.BulkIndexerItem, error) {
+	item := es.BulkIndexerItem{
+		Action: opts.action,
+		Body:   json.RawMessage(line),
+	}
+	if !json.Valid(line) {
+		return item, errors.New("invalid JSON")
+	}
+	if opts.idField == "" {
+		return item, nil
+	}
+
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return item, err
+	}
+	switch id := lookupField(doc, opts.idField).(type) {
+	case string:
+		item.DocumentID = id
+	case json.Number:
+		item.DocumentID = id.String()
+	case nil:
+		return item, fmt.Errorf("document has no field %q", opts.idField)
+	default:
+		return item, fmt.Errorf("field %q must be a string or number", opts.idField)
+	}
+	return item, nil
+}
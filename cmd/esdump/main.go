@@ -0,0 +1,109 @@
+// esdump 导出 es 索引到 NDJSON、CSV 文件，或将 NDJSON 文件导入索引
+//
+//	esdump export -index products -query query.json -format csv -fields id,name,price -out products.csv
+//	esdump import -index products_copy -in products.ndjson -batch-size 2000 -workers 4 -id-field id
+//
+// 连接配置通过 cfg.InitConfiguration 读取，默认读取当前目录下的 config.yaml：
+//
+//	es:
+//	  addresses: ["https://127.0.0.1:9200"]
+//	  user: elastic
+//	  password: changeme
+//	  cacertpath: /etc/elasticsearch/certs/http_ca.crt
+//	loglevel: info
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/sirupsen/logrus"
+	"github.com/yzlq99/go_utils/utils/client"
+	cfg "github.com/yzlq99/go_utils/utils/config"
+	"github.com/yzlq99/go_utils/utils/es"
+)
+
+type configuration struct {
+	ES       cfg.ESConfiguration
+	LogLevel cfg.LevelMode
+}
+
+// connectionFlags 所有子命令共用的配置文件参数
+type connectionFlags struct {
+	configName  string
+	configPaths string
+}
+
+func (f *connectionFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.configName, "config", "config", "config file name without extension")
+	fs.StringVar(&f.configPaths, "config-path", ".", "comma separated directories to search for the config file")
+}
+
+// connect 读取配置并创建客户端，同时按配置的日志级别设置 es 请求日志
+func (f *connectionFlags) connect() (*elasticsearch.Client, *logrus.Logger, error) {
+	var config configuration
+	if err := cfg.InitConfiguration(f.configName, strings.Split(f.configPaths, ","), &config); err != nil {
+		return nil, nil, fmt.Errorf("read config failed, %v", err)
+	}
+	if config.LogLevel == "" {
+		config.LogLevel = cfg.LevelInfo
+	}
+	logger := es.NewLogger(config.LogLevel)
+	logger.SetOutput(os.Stderr)
+	es.SetLogger(logger)
+
+	esClient, err := client.InitElasticsearch(config.ES)
+	if err != nil {
+		return nil, nil, err
+	}
+	return esClient, logger, nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage:
+  esdump export -index <index> [options]   export documents to NDJSON or CSV
+  esdump import -index <index> [options]   import documents from NDJSON
+
+Run "esdump <command> -h" for the options of each command.`)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = runExport(ctx, os.Args[2:])
+	case "import":
+		err = runImport(ctx, os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "esdump:", err)
+		os.Exit(1)
+	}
+}
@@ -1,42 +1,45 @@
 package client
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/pkg/errors"
 	cfg "github.com/yzlq99/go_utils/utils/config"
 )
 
-// InitElasticsearch ...
+// InitElasticsearch 创建 es 客户端并检查集群是否可用，集群不可用时返回错误
+// 配置了 CA 证书或证书指纹时使用它们校验服务端证书，否则使用系统的根证书校验，
+// 只有显式设置 Insecure 时才跳过校验
 func InitElasticsearch(config cfg.ESConfiguration) (*elasticsearch.Client, error) {
-	var cfg = elasticsearch.Config{
-		Addresses: []string{
-			config.Host,
-		},
-		Username: config.User,
-		Password: config.Password,
-		Transport: &http.Transport{
-			MaxIdleConnsPerHost:   10,
-			ResponseHeaderTimeout: time.Duration(config.ResponseHeaderTimeoutSeconds) * time.Second,
-			DialContext:           (&net.Dialer{Timeout: time.Second}).DialContext,
-			TLSClientConfig: &tls.Config{
-				MinVersion:         tls.VersionTLS11,
-				InsecureSkipVerify: true,
-			},
-		},
-	}
-
-	esClient, err := elasticsearch.NewClient(cfg)
+	tlsConfig, err := newTLSConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = esClient.Ping()
+	esClient, err := elasticsearch.NewClient(newElasticsearchConfig(config, tlsConfig))
 	if err != nil {
-		panic(err)
+		return nil, errors.WithStack(err)
+	}
+
+	res, err := esClient.Ping()
+	if err != nil {
+		return nil, errors.Wrap(err, "ping elasticsearch failed")
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, errors.Errorf("ping elasticsearch failed, %s", res.Status())
 	}
 
 	return esClient, nil
@@ -44,23 +47,127 @@ func InitElasticsearch(config cfg.ESConfiguration) (*elasticsearch.Client, error
 
 // InitElasticsearchWithoutTLS ...
 func InitElasticsearchWithoutTLS(config cfg.ESConfiguration) (*elasticsearch.Client, error) {
-	var cfg = elasticsearch.Config{
-		Addresses: []string{
-			config.Host,
-		},
-		Username: config.User,
-		Password: config.Password,
-		Transport: &http.Transport{
-			MaxIdleConnsPerHost:   10,
-			ResponseHeaderTimeout: time.Duration(config.ResponseHeaderTimeoutSeconds) * time.Second,
-			DialContext:           (&net.Dialer{Timeout: time.Second}).DialContext,
-		},
-	}
-
-	esClient, err := elasticsearch.NewClient(cfg)
+	esClient, err := elasticsearch.NewClient(newElasticsearchConfig(config, nil))
 	if err != nil {
 		return nil, err
 	}
 
 	return esClient, nil
 }
+
+func newElasticsearchConfig(config cfg.ESConfiguration, tlsConfig *tls.Config) elasticsearch.Config {
+	addresses := config.Addresses
+	if len(addresses) == 0 && config.Host != "" {
+		addresses = []string{config.Host}
+	}
+	if config.CloudID != "" {
+		addresses = nil
+	}
+
+	var transport http.RoundTripper = &http.Transport{
+		MaxIdleConnsPerHost:   10,
+		ResponseHeaderTimeout: time.Duration(config.ResponseHeaderTimeoutSeconds) * time.Second,
+		DialContext:           (&net.Dialer{Timeout: time.Second}).DialContext,
+		TLSClientConfig:       tlsConfig,
+	}
+	if config.CompressRequestBody {
+		transport = &gzipTransport{next: transport}
+	}
+
+	return elasticsearch.Config{
+		Addresses:             addresses,
+		Username:              config.User,
+		Password:              config.Password,
+		APIKey:                config.APIKey,
+		CloudID:               config.CloudID,
+		RetryOnStatus:         config.RetryOnStatus,
+		MaxRetries:            config.MaxRetries,
+		DisableRetry:          config.DisableRetry,
+		DiscoverNodesOnStart:  config.DiscoverNodesOnStart,
+		DiscoverNodesInterval: time.Duration(config.DiscoverNodesIntervalSeconds) * time.Second,
+		Transport:             transport,
+	}
+}
+
+func newTLSConfig(config cfg.ESConfiguration) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS11,
+	}
+
+	caCert := []byte(config.CACert)
+	if config.CACertPath != "" {
+		cert, err := ioutil.ReadFile(config.CACertPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "read CA certificate failed")
+		}
+		caCert = append(caCert, '\n')
+		caCert = append(caCert, cert...)
+	}
+	hasCACert := len(bytes.TrimSpace(caCert)) > 0
+	if hasCACert {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("no valid certificate found in CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertificateFingerprint != "" {
+		fingerprint, err := hex.DecodeString(strings.ReplaceAll(config.CertificateFingerprint, ":", ""))
+		if err != nil || len(fingerprint) != sha256.Size {
+			return nil, errors.Errorf("invalid SHA-256 certificate fingerprint %q", config.CertificateFingerprint)
+		}
+		// 只配置了指纹时，证书链不需要由受信任的 CA 签发，只要证书链中有证书与指纹一致
+		tlsConfig.InsecureSkipVerify = !hasCACert
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			for _, rawCert := range rawCerts {
+				sum := sha256.Sum256(rawCert)
+				if bytes.Equal(sum[:], fingerprint) {
+					return nil
+				}
+			}
+			return errors.New("server certificate does not match the configured fingerprint")
+		}
+		return tlsConfig, nil
+	}
+
+	if !hasCACert && config.Insecure {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig, nil
+}
+
+// gzipTransport 使用 gzip 压缩请求体，响应由 http.Transport 自动解压
+type gzipTransport struct {
+	next http.RoundTripper
+}
+
+func (t *gzipTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Header.Get("Content-Encoding") != "" {
+		return t.next.RoundTrip(req)
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "read request body failed")
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return nil, errors.Wrap(err, "compress request body failed")
+	}
+	if err := zw.Close(); err != nil {
+		return nil, errors.Wrap(err, "compress request body failed")
+	}
+
+	compressed := buf.Bytes()
+	r := req.Clone(req.Context())
+	r.Header.Set("Content-Encoding", "gzip")
+	r.ContentLength = int64(len(compressed))
+	r.Body = ioutil.NopCloser(bytes.NewReader(compressed))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	return t.next.RoundTrip(r)
+}
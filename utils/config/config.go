@@ -27,10 +27,41 @@ type PostgresConfiguration struct {
 
 // ESConfiguration  configuration for elasticsearch connection
 type ESConfiguration struct {
-	Host                         string
+	Host string
+	// Addresses 多个节点的地址，不为空时忽略 Host
+	Addresses                    []string
 	User                         string
 	Password                     string
 	ResponseHeaderTimeoutSeconds int
+
+	// APIKey base64 编码的 API key，设置后忽略 User、Password
+	APIKey string
+	// CloudID Elastic Cloud 的 cloud id，设置后忽略 Host、Addresses
+	CloudID string
+
+	// CACert PEM 格式的 CA 证书内容，CACertPath 为证书文件路径，两者都设置时合并使用
+	CACert     string
+	CACertPath string
+	// CertificateFingerprint 服务端证书（或其证书链中任意一个证书）的 SHA-256 指纹，十六进制，可以带冒号
+	// 没有配置 CA 证书和指纹时使用系统的根证书校验服务端证书
+	CertificateFingerprint string
+	// Insecure 不校验服务端证书，只应在测试环境中使用；配置了 CA 证书或指纹时忽略
+	Insecure bool
+
+	// DiscoverNodesOnStart 初始化时通过 _nodes/http 获取集群的所有节点
+	DiscoverNodesOnStart bool
+	// DiscoverNodesIntervalSeconds 定时更新节点列表的间隔，0 表示不更新
+	DiscoverNodesIntervalSeconds int
+
+	// CompressRequestBody 使用 gzip 压缩请求体
+	CompressRequestBody bool
+
+	// RetryOnStatus 客户端自动重试的状态码，默认 502、503、504
+	// es 包的 RetryPolicy 在此之上重试，默认只处理 429，见 es.DefaultRetryPolicy
+	RetryOnStatus []int
+	// MaxRetries 客户端自动重试的次数，默认 3
+	MaxRetries   int
+	DisableRetry bool
 }
 
 // RedisConfiguration ...
@@ -0,0 +1,537 @@
+package es
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Aggregation 聚合，Map 返回的内容即 es 查询语句 aggs 中对应的部分
+//
+//	source := es.NewSearchSource().Size(0).
+//		Aggregation("by_status", es.NewTermsAggregation("status").Size(10).
+//			SubAggregation("users", es.NewCardinalityAggregation("user_id")))
+type Aggregation interface {
+	Map() map[string]interface{}
+}
+
+// subAggregations 可以包含子聚合的聚合共用
+type subAggregations map[string]Aggregation
+
+func (s subAggregations) apply(body map[string]interface{}) map[string]interface{} {
+	if len(s) > 0 {
+		aggs := make(map[string]interface{}, len(s))
+		for name, agg := range s {
+			aggs[name] = agg.Map()
+		}
+		body["aggs"] = aggs
+	}
+	return body
+}
+
+// TermsAggregation terms 分桶聚合
+type TermsAggregation struct {
+	field       string
+	size        *int
+	minDocCount *int
+	order       []map[string]interface{}
+	missing     interface{}
+	subs        subAggregations
+}
+
+// NewTermsAggregation ...
+func NewTermsAggregation(field string) *TermsAggregation {
+	return &TermsAggregation{field: field, subs: subAggregations{}}
+}
+
+// Size 返回的桶数
+func (a *TermsAggregation) Size(size int) *TermsAggregation {
+	a.size = &size
+	return a
+}
+
+// MinDocCount ...
+func (a *TermsAggregation) MinDocCount(minDocCount int) *TermsAggregation {
+	a.minDocCount = &minDocCount
+	return a
+}
+
+// Order 桶的排序，key 可以是 _count、_key 或子聚合名称
+func (a *TermsAggregation) Order(key string, ascending bool) *TermsAggregation {
+	order := "asc"
+	if !ascending {
+		order = "desc"
+	}
+	a.order = append(a.order, map[string]interface{}{key: order})
+	return a
+}
+
+// Missing 字段不存在的文档归入该值的桶
+func (a *TermsAggregation) Missing(missing interface{}) *TermsAggregation {
+	a.missing = missing
+	return a
+}
+
+// SubAggregation ...
+func (a *TermsAggregation) SubAggregation(name string, agg Aggregation) *TermsAggregation {
+	a.subs[name] = agg
+	return a
+}
+
+// Map ...
+func (a *TermsAggregation) Map() map[string]interface{} {
+	terms := map[string]interface{}{"field": a.field}
+	if a.size != nil {
+		terms["size"] = *a.size
+	}
+	if a.minDocCount != nil {
+		terms["min_doc_count"] = *a.minDocCount
+	}
+	if len(a.order) > 0 {
+		terms["order"] = a.order
+	}
+	if a.missing != nil {
+		terms["missing"] = a.missing
+	}
+	return a.subs.apply(map[string]interface{}{"terms": terms})
+}
+
+// DateHistogramAggregation date_histogram 按时间分桶聚合
+type DateHistogramAggregation struct {
+	field            string
+	calendarInterval string
+	fixedInterval    string
+	format           string
+	timeZone         string
+	minDocCount      *int
+	extendedBounds   map[string]interface{}
+	subs             subAggregations
+}
+
+// NewDateHistogramAggregation ...
+func NewDateHistogramAggregation(field string) *DateHistogramAggregation {
+	return &DateHistogramAggregation{field: field, subs: subAggregations{}}
+}
+
+// CalendarInterval 如 1d、1w、1M
+func (a *DateHistogramAggregation) CalendarInterval(interval string) *DateHistogramAggregation {
+	a.calendarInterval = interval
+	return a
+}
+
+// FixedInterval 如 30m、12h
+func (a *DateHistogramAggregation) FixedInterval(interval string) *DateHistogramAggregation {
+	a.fixedInterval = interval
+	return a
+}
+
+// Format key_as_string 的格式，如 yyyy-MM-dd
+func (a *DateHistogramAggregation) Format(format string) *DateHistogramAggregation {
+	a.format = format
+	return a
+}
+
+// TimeZone 如 +08:00
+func (a *DateHistogramAggregation) TimeZone(timeZone string) *DateHistogramAggregation {
+	a.timeZone = timeZone
+	return a
+}
+
+// MinDocCount 为 0 时返回空桶
+func (a *DateHistogramAggregation) MinDocCount(minDocCount int) *DateHistogramAggregation {
+	a.minDocCount = &minDocCount
+	return a
+}
+
+// ExtendedBounds 强制返回 min ~ max 之间的桶
+func (a *DateHistogramAggregation) ExtendedBounds(min interface{}, max interface{}) *DateHistogramAggregation {
+	a.extendedBounds = map[string]interface{}{"min": min, "max": max}
+	return a
+}
+
+// SubAggregation ...
+func (a *DateHistogramAggregation) SubAggregation(name string, agg Aggregation) *DateHistogramAggregation {
+	a.subs[name] = agg
+	return a
+}
+
+// Map ...
+func (a *DateHistogramAggregation) Map() map[string]interface{} {
+	histogram := map[string]interface{}{"field": a.field}
+	if a.calendarInterval != "" {
+		histogram["calendar_interval"] = a.calendarInterval
+	}
+	if a.fixedInterval != "" {
+		histogram["fixed_interval"] = a.fixedInterval
+	}
+	if a.format != "" {
+		histogram["format"] = a.format
+	}
+	if a.timeZone != "" {
+		histogram["time_zone"] = a.timeZone
+	}
+	if a.minDocCount != nil {
+		histogram["min_doc_count"] = *a.minDocCount
+	}
+	if a.extendedBounds != nil {
+		histogram["extended_bounds"] = a.extendedBounds
+	}
+	return a.subs.apply(map[string]interface{}{"date_histogram": histogram})
+}
+
+// MetricAggregation 单值指标聚合，如 sum、avg、min、max、value_count
+type MetricAggregation struct {
+	kind    string
+	field   string
+	missing interface{}
+}
+
+// NewSumAggregation ...
+func NewSumAggregation(field string) *MetricAggregation {
+	return &MetricAggregation{kind: "sum", field: field}
+}
+
+// NewAvgAggregation ...
+func NewAvgAggregation(field string) *MetricAggregation {
+	return &MetricAggregation{kind: "avg", field: field}
+}
+
+// NewMinAggregation ...
+func NewMinAggregation(field string) *MetricAggregation {
+	return &MetricAggregation{kind: "min", field: field}
+}
+
+// NewMaxAggregation ...
+func NewMaxAggregation(field string) *MetricAggregation {
+	return &MetricAggregation{kind: "max", field: field}
+}
+
+// NewValueCountAggregation ...
+func NewValueCountAggregation(field string) *MetricAggregation {
+	return &MetricAggregation{kind: "value_count", field: field}
+}
+
+// Missing 字段不存在时使用的值
+func (a *MetricAggregation) Missing(missing interface{}) *MetricAggregation {
+	a.missing = missing
+	return a
+}
+
+// Map ...
+func (a *MetricAggregation) Map() map[string]interface{} {
+	body := map[string]interface{}{"field": a.field}
+	if a.missing != nil {
+		body["missing"] = a.missing
+	}
+	return map[string]interface{}{a.kind: body}
+}
+
+// CardinalityAggregation cardinality 去重计数聚合
+type CardinalityAggregation struct {
+	field              string
+	precisionThreshold *int
+}
+
+// NewCardinalityAggregation ...
+func NewCardinalityAggregation(field string) *CardinalityAggregation {
+	return &CardinalityAggregation{field: field}
+}
+
+// PrecisionThreshold 低于该值时计数接近精确，最大 40000
+func (a *CardinalityAggregation) PrecisionThreshold(threshold int) *CardinalityAggregation {
+	a.precisionThreshold = &threshold
+	return a
+}
+
+// Map ...
+func (a *CardinalityAggregation) Map() map[string]interface{} {
+	body := map[string]interface{}{"field": a.field}
+	if a.precisionThreshold != nil {
+		body["precision_threshold"] = *a.precisionThreshold
+	}
+	return map[string]interface{}{"cardinality": body}
+}
+
+// PercentilesAggregation percentiles 百分位聚合
+type PercentilesAggregation struct {
+	field    string
+	percents []float64
+}
+
+// NewPercentilesAggregation ...
+func NewPercentilesAggregation(field string) *PercentilesAggregation {
+	return &PercentilesAggregation{field: field}
+}
+
+// Percents 需要计算的百分位，默认 1、5、25、50、75、95、99
+func (a *PercentilesAggregation) Percents(percents ...float64) *PercentilesAggregation {
+	a.percents = append(a.percents, percents...)
+	return a
+}
+
+// Map ...
+func (a *PercentilesAggregation) Map() map[string]interface{} {
+	body := map[string]interface{}{"field": a.field}
+	if len(a.percents) > 0 {
+		body["percents"] = a.percents
+	}
+	return map[string]interface{}{"percentiles": body}
+}
+
+// NestedAggregation nested 聚合，在 nested 类型字段内做子聚合
+type NestedAggregation struct {
+	path string
+	subs subAggregations
+}
+
+// NewNestedAggregation ...
+func NewNestedAggregation(path string) *NestedAggregation {
+	return &NestedAggregation{path: path, subs: subAggregations{}}
+}
+
+// SubAggregation ...
+func (a *NestedAggregation) SubAggregation(name string, agg Aggregation) *NestedAggregation {
+	a.subs[name] = agg
+	return a
+}
+
+// Map ...
+func (a *NestedAggregation) Map() map[string]interface{} {
+	return a.subs.apply(map[string]interface{}{
+		"nested": map[string]interface{}{"path": a.path},
+	})
+}
+
+// FilterAggregation filter 聚合，只对满足条件的文档做子聚合
+type FilterAggregation struct {
+	filter Query
+	subs   subAggregations
+}
+
+// NewFilterAggregation filter 为 nil 时对所有文档做子聚合
+func NewFilterAggregation(filter Query) *FilterAggregation {
+	return &FilterAggregation{filter: filter, subs: subAggregations{}}
+}
+
+// SubAggregation ...
+func (a *FilterAggregation) SubAggregation(name string, agg Aggregation) *FilterAggregation {
+	a.subs[name] = agg
+	return a
+}
+
+// Map ...
+func (a *FilterAggregation) Map() map[string]interface{} {
+	return a.subs.apply(map[string]interface{}{"filter": queryMap(a.filter)})
+}
+
+// Aggregations 查询结果中的 aggregations，聚合不存在或格式不符时返回零值，不会 panic
+//
+//	var response struct {
+//		Aggs es.Aggregations `json:"aggregations"`
+//	}
+//	err := es.PerformESQuery(source, &response, index, esClient)
+//	for _, bucket := range response.Aggs.Terms("by_status").Buckets() {
+//		users, _ := bucket.Aggs.Cardinality("users").Value()
+//		...
+//	}
+type Aggregations map[string]json.RawMessage
+
+// Terms terms 聚合的结果
+func (a Aggregations) Terms(name string) BucketAggregationResult {
+	var result BucketAggregationResult
+	a.decode(name, &result)
+	return result
+}
+
+// DateHistogram date_histogram 聚合的结果
+func (a Aggregations) DateHistogram(name string) BucketAggregationResult {
+	return a.Terms(name)
+}
+
+// Metric sum、avg、min、max、value_count 等单值聚合的结果
+func (a Aggregations) Metric(name string) ValueAggregationResult {
+	var result ValueAggregationResult
+	a.decode(name, &result)
+	return result
+}
+
+// Cardinality cardinality 聚合的结果
+func (a Aggregations) Cardinality(name string) ValueAggregationResult {
+	return a.Metric(name)
+}
+
+// Percentiles percentiles 聚合的结果
+func (a Aggregations) Percentiles(name string) PercentilesAggregationResult {
+	var result PercentilesAggregationResult
+	a.decode(name, &result)
+	return result
+}
+
+// Nested nested、filter 等单桶聚合的结果
+func (a Aggregations) Nested(name string) SingleBucketAggregationResult {
+	var result SingleBucketAggregationResult
+	a.decode(name, &result)
+	return result
+}
+
+// Filter filter 聚合的结果
+func (a Aggregations) Filter(name string) SingleBucketAggregationResult {
+	return a.Nested(name)
+}
+
+// Sub nested、filter 等单桶聚合下的子聚合，如 Aggs.Sub("comments").Terms("by_author")
+func (a Aggregations) Sub(name string) Aggregations {
+	return a.Nested(name).Aggs
+}
+
+// Raw 聚合的原始内容，用于没有对应方法的聚合类型
+func (a Aggregations) Raw(name string) json.RawMessage {
+	return a[name]
+}
+
+func (a Aggregations) decode(name string, v interface{}) {
+	raw, ok := a[name]
+	if !ok {
+		return
+	}
+	// 格式不符时保持零值：json 遇到类型错误时仍会写入部分字段，因此先解析到新的值中
+	target := reflect.ValueOf(v).Elem()
+	decoded := reflect.New(target.Type())
+	if err := json.Unmarshal(raw, decoded.Interface()); err == nil {
+		target.Set(decoded.Elem())
+	}
+}
+
+// Bucket 分桶聚合中的一个桶，Aggs 为桶内的子聚合
+type Bucket struct {
+	Key         interface{}
+	KeyAsString string
+	DocCount    int64
+	Aggs        Aggregations
+}
+
+// UnmarshalJSON 桶中除 key、key_as_string、doc_count 以外的字段均为子聚合
+func (b *Bucket) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	b.Aggs = Aggregations{}
+	for name, raw := range fields {
+		var err error
+		switch name {
+		case "key":
+			err = json.Unmarshal(raw, &b.Key)
+		case "key_as_string":
+			err = json.Unmarshal(raw, &b.KeyAsString)
+		case "doc_count":
+			err = json.Unmarshal(raw, &b.DocCount)
+		default:
+			b.Aggs[name] = raw
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KeyString 桶的 key，有 key_as_string 时优先使用
+func (b Bucket) KeyString() string {
+	if b.KeyAsString != "" {
+		return b.KeyAsString
+	}
+	switch key := b.Key.(type) {
+	case string:
+		return key
+	case nil:
+		return ""
+	}
+	raw, _ := json.Marshal(b.Key)
+	return string(raw)
+}
+
+// BucketAggregationResult terms、date_histogram 等多桶聚合的结果
+type BucketAggregationResult struct {
+	DocCountErrorUpperBound int64    `json:"doc_count_error_upper_bound"`
+	SumOtherDocCount        int64    `json:"sum_other_doc_count"`
+	RawBuckets              []Bucket `json:"buckets"`
+}
+
+// Buckets ...
+func (r BucketAggregationResult) Buckets() []Bucket {
+	return r.RawBuckets
+}
+
+// Bucket 按 key 查找桶
+func (r BucketAggregationResult) Bucket(key string) (Bucket, bool) {
+	for _, b := range r.RawBuckets {
+		if b.KeyString() == key {
+			return b, true
+		}
+	}
+	return Bucket{}, false
+}
+
+// ValueAggregationResult 单值聚合的结果
+type ValueAggregationResult struct {
+	RawValue      *float64 `json:"value"`
+	ValueAsString string   `json:"value_as_string"`
+}
+
+// Value 没有数据时（如对空集合求 avg）ok 为 false
+func (r ValueAggregationResult) Value() (value float64, ok bool) {
+	if r.RawValue == nil {
+		return 0, false
+	}
+	return *r.RawValue, true
+}
+
+// PercentilesAggregationResult percentiles 聚合的结果
+type PercentilesAggregationResult struct {
+	RawValues map[string]*float64 `json:"values"`
+}
+
+// Values 百分位到值的映射，key 如 "50.0"，没有数据的百分位不会返回
+func (r PercentilesAggregationResult) Values() map[string]float64 {
+	values := make(map[string]float64, len(r.RawValues))
+	for percent, value := range r.RawValues {
+		if value != nil {
+			values[percent] = *value
+		}
+	}
+	return values
+}
+
+// Percents 按百分位从小到大返回
+func (r PercentilesAggregationResult) Percents() []string {
+	values := r.Values()
+	percents := make([]string, 0, len(values))
+	for percent := range values {
+		percents = append(percents, percent)
+	}
+	sort.Slice(percents, func(i, j int) bool {
+		a, _ := strconv.ParseFloat(percents[i], 64)
+		b, _ := strconv.ParseFloat(percents[j], 64)
+		return a < b
+	})
+	return percents
+}
+
+// SingleBucketAggregationResult nested、filter 等单桶聚合的结果
+type SingleBucketAggregationResult struct {
+	DocCount int64
+	Aggs     Aggregations
+}
+
+// UnmarshalJSON 除 doc_count 以外的字段均为子聚合
+func (r *SingleBucketAggregationResult) UnmarshalJSON(data []byte) error {
+	var b Bucket
+	if err := b.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	r.DocCount = b.DocCount
+	r.Aggs = b.Aggs
+	return nil
+}
@@ -0,0 +1,242 @@
+package es
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestAggregationJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		agg  Aggregation
+		want string
+	}{
+		{
+			name: "terms",
+			agg: NewTermsAggregation("status").Size(10).MinDocCount(1).Order("_count", false).Missing("unknown").
+				SubAggregation("users", NewCardinalityAggregation("user_id").PrecisionThreshold(1000)),
+			want: `{"aggs":{"users":{"cardinality":{"field":"user_id","precision_threshold":1000}}},` +
+				`"terms":{"field":"status","min_doc_count":1,"missing":"unknown","order":[{"_count":"desc"}],"size":10}}`,
+		},
+		{
+			name: "date_histogram",
+			agg: NewDateHistogramAggregation("created_at").CalendarInterval("1d").Format("yyyy-MM-dd").
+				TimeZone("+08:00").MinDocCount(0).ExtendedBounds("2021-01-01", "2021-01-31").
+				SubAggregation("amount", NewSumAggregation("amount")),
+			want: `{"aggs":{"amount":{"sum":{"field":"amount"}}},` +
+				`"date_histogram":{"calendar_interval":"1d","extended_bounds":{"max":"2021-01-31","min":"2021-01-01"},` +
+				`"field":"created_at","format":"yyyy-MM-dd","min_doc_count":0,"time_zone":"+08:00"}}`,
+		},
+		{
+			name: "fixed interval",
+			agg:  NewDateHistogramAggregation("created_at").FixedInterval("30m"),
+			want: `{"date_histogram":{"field":"created_at","fixed_interval":"30m"}}`,
+		},
+		{
+			name: "metrics",
+			agg:  NewAvgAggregation("price").Missing(0),
+			want: `{"avg":{"field":"price","missing":0}}`,
+		},
+		{
+			name: "percentiles",
+			agg:  NewPercentilesAggregation("latency").Percents(50, 99.9),
+			want: `{"percentiles":{"field":"latency","percents":[50,99.9]}}`,
+		},
+		{
+			name: "nested",
+			agg:  NewNestedAggregation("comments").SubAggregation("by_author", NewTermsAggregation("comments.author")),
+			want: `{"aggs":{"by_author":{"terms":{"field":"comments.author"}}},"nested":{"path":"comments"}}`,
+		},
+		{
+			name: "filter",
+			agg:  NewFilterAggregation(NewTermQuery("status", "active")).SubAggregation("max", NewMaxAggregation("price")),
+			want: `{"aggs":{"max":{"max":{"field":"price"}}},"filter":{"term":{"status":"active"}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.agg.Map())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+
+	source := NewSearchSource().Size(0).
+		Aggregation("by_status", NewTermsAggregation("status")).
+		Aggregation("total", NewValueCountAggregation("id"))
+	got, err := json.Marshal(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"aggs":{"by_status":{"terms":{"field":"status"}},"total":{"value_count":{"field":"id"}}},"size":0}`
+	if string(got) != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+// aggregationResponse 与 es 返回的格式一致
+const aggregationResponse = `{
+	"took": 3,
+	"timed_out": false,
+	"hits": {"total": {"value": 6, "relation": "eq"}, "max_score": null, "hits": []},
+	"aggregations": {
+		"by_status": {
+			"doc_count_error_upper_bound": 0,
+			"sum_other_doc_count": 2,
+			"buckets": [
+				{"key": "active", "doc_count": 3, "users": {"value": 2}, "avg_price": {"value": 12.5}},
+				{"key": "deleted", "doc_count": 1, "users": {"value": 1}, "avg_price": {"value": null}}
+			]
+		},
+		"by_day": {
+			"buckets": [
+				{"key_as_string": "2021-01-01", "key": 1609459200000, "doc_count": 4},
+				{"key_as_string": "2021-01-02", "key": 1609545600000, "doc_count": 0}
+			]
+		},
+		"by_flag": {
+			"doc_count_error_upper_bound": 0,
+			"sum_other_doc_count": 0,
+			"buckets": [{"key": 1, "key_as_string": "true", "doc_count": 5}, {"key": 42, "doc_count": 1}]
+		},
+		"max_price": {"value": 30.0, "value_as_string": "30.0"},
+		"empty_avg": {"value": null},
+		"latency": {"values": {"1.0": 1.5, "50.0": 10.0, "99.9": 120.0, "5.0": null}},
+		"comments": {
+			"doc_count": 8,
+			"by_author": {
+				"doc_count_error_upper_bound": 0,
+				"sum_other_doc_count": 0,
+				"buckets": [{"key": "tom", "doc_count": 5}]
+			}
+		},
+		"active": {"doc_count": 3, "max": {"value": 20.0}},
+		"malformed": {"buckets": "not an array", "values": 1, "value": "abc"}
+	}
+}`
+
+func TestAggregationsResult(t *testing.T) {
+	var response SearchResponse
+	if err := json.Unmarshal([]byte(aggregationResponse), &response); err != nil {
+		t.Fatal(err)
+	}
+	aggs := response.Aggregations
+
+	byStatus := aggs.Terms("by_status")
+	if byStatus.SumOtherDocCount != 2 || len(byStatus.Buckets()) != 2 {
+		t.Fatalf("unexpected terms result %+v", byStatus)
+	}
+	active, ok := byStatus.Bucket("active")
+	if !ok || active.DocCount != 3 {
+		t.Fatalf("unexpected active bucket %+v", active)
+	}
+	if users, ok := active.Aggs.Cardinality("users").Value(); !ok || users != 2 {
+		t.Fatalf("expected 2 users, got %v %v", users, ok)
+	}
+	if avg, ok := active.Aggs.Metric("avg_price").Value(); !ok || avg != 12.5 {
+		t.Fatalf("expected avg 12.5, got %v %v", avg, ok)
+	}
+	deleted, _ := byStatus.Bucket("deleted")
+	if _, ok := deleted.Aggs.Metric("avg_price").Value(); ok {
+		t.Fatal("null value should not be ok")
+	}
+	if _, ok := byStatus.Bucket("missing"); ok {
+		t.Fatal("unexpected bucket")
+	}
+
+	var days []string
+	for _, bucket := range aggs.DateHistogram("by_day").Buckets() {
+		days = append(days, bucket.KeyString())
+	}
+	if !reflect.DeepEqual(days, []string{"2021-01-01", "2021-01-02"}) {
+		t.Fatalf("unexpected days %v", days)
+	}
+	var keys []string
+	for _, bucket := range aggs.Terms("by_flag").Buckets() {
+		keys = append(keys, bucket.KeyString())
+	}
+	if !reflect.DeepEqual(keys, []string{"true", "42"}) {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	if max, ok := aggs.Metric("max_price").Value(); !ok || max != 30 {
+		t.Fatalf("expected max 30, got %v %v", max, ok)
+	}
+	if _, ok := aggs.Metric("empty_avg").Value(); ok {
+		t.Fatal("null value should not be ok")
+	}
+
+	latency := aggs.Percentiles("latency")
+	if !reflect.DeepEqual(latency.Percents(), []string{"1.0", "50.0", "99.9"}) {
+		t.Fatalf("unexpected percents %v", latency.Percents())
+	}
+	if latency.Values()["99.9"] != 120 {
+		t.Fatalf("unexpected values %v", latency.Values())
+	}
+
+	comments := aggs.Nested("comments")
+	if comments.DocCount != 8 {
+		t.Fatalf("expected 8 nested documents, got %d", comments.DocCount)
+	}
+	tom, ok := aggs.Sub("comments").Terms("by_author").Bucket("tom")
+	if !ok || tom.DocCount != 5 {
+		t.Fatalf("unexpected nested bucket %+v", tom)
+	}
+	if max, ok := aggs.Filter("active").Aggs.Metric("max").Value(); !ok || max != 20 {
+		t.Fatalf("expected max 20, got %v %v", max, ok)
+	}
+	if len(aggs.Raw("latency")) == 0 {
+		t.Fatal("expected raw aggregation")
+	}
+}
+
+func TestAggregationsMissing(t *testing.T) {
+	var response SearchResponse
+	if err := json.Unmarshal([]byte(aggregationResponse), &response); err != nil {
+		t.Fatal(err)
+	}
+	var nilAggs Aggregations
+
+	for _, aggs := range []Aggregations{response.Aggregations, nilAggs} {
+		// 不存在的聚合、不存在的子聚合以及多层不存在的路径都返回零值
+		if buckets := aggs.Terms("missing").Buckets(); len(buckets) != 0 {
+			t.Fatalf("unexpected buckets %v", buckets)
+		}
+		if _, ok := aggs.Metric("missing").Value(); ok {
+			t.Fatal("missing metric should not be ok")
+		}
+		if values := aggs.Percentiles("missing").Values(); len(values) != 0 {
+			t.Fatalf("unexpected values %v", values)
+		}
+		if n := aggs.Nested("missing").DocCount; n != 0 {
+			t.Fatalf("unexpected doc count %d", n)
+		}
+		if _, ok := aggs.Sub("missing").Sub("deeper").Terms("by_author").Bucket("tom"); ok {
+			t.Fatal("unexpected bucket")
+		}
+		if aggs.Raw("missing") != nil {
+			t.Fatal("unexpected raw aggregation")
+		}
+	}
+
+	// 类型不符时返回零值
+	aggs := response.Aggregations
+	if buckets := aggs.Terms("malformed").Buckets(); len(buckets) != 0 {
+		t.Fatalf("unexpected buckets %v", buckets)
+	}
+	if _, ok := aggs.Metric("malformed").Value(); ok {
+		t.Fatal("malformed metric should not be ok")
+	}
+	if values := aggs.Percentiles("malformed").Values(); len(values) != 0 {
+		t.Fatalf("unexpected values %v", values)
+	}
+	if _, ok := aggs.Metric("by_status").Value(); ok {
+		t.Fatal("bucket aggregation is not a metric")
+	}
+}
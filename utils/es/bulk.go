@@ -0,0 +1,139 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/sirupsen/logrus"
+)
+
+// BulkResult bulk 请求的处理结果
+type BulkResult struct {
+	Took   int
+	Errors bool
+	Items  []BulkItemResult
+}
+
+// BulkItemResult bulk 响应中单个文档的处理结果
+type BulkItemResult struct {
+	Position int            `json:"-"` // 文档在请求中的序号，从 0 开始
+	Action   string         `json:"-"` // index、create、update、delete
+	Index    string         `json:"_index"`
+	ID       string         `json:"_id"`
+	Version  int64          `json:"_version"`
+	Result   string         `json:"result"`
+	Status   int            `json:"status"`
+	Error    *BulkItemError `json:"error,omitempty"`
+}
+
+// BulkItemError 单个文档的错误信息
+type BulkItemError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// Failed 文档是否处理失败，以 error 为准：
+// delete 不存在的文档时 es 返回 404、result 为 not_found，但没有 error，不算失败
+func (r BulkItemResult) Failed() bool {
+	return r.Error != nil
+}
+
+// Failed 返回处理失败的文档
+func (r *BulkResult) Failed() []BulkItemResult {
+	var failed []BulkItemResult
+	for _, item := range r.Items {
+		if item.Failed() {
+			failed = append(failed, item)
+		}
+	}
+	return failed
+}
+
+// BulkError bulk 请求成功但部分文档处理失败，如 mapping 冲突、版本冲突等
+// 可以根据 Items 中的 Position 或 ID 重试或单独记录失败的文档
+type BulkError struct {
+	Items []BulkItemResult
+}
+
+func (e *BulkError) Error() string {
+	if len(e.Items) == 0 {
+		return "bulk: no failed items"
+	}
+	first := e.Items[0]
+	reason := ""
+	if first.Error != nil {
+		reason = fmt.Sprintf(" %s: %s", first.Error.Type, first.Error.Reason)
+	}
+	return fmt.Sprintf("bulk: %d items failed, first: %s %s [%d]%s",
+		len(e.Items), first.Action, first.ID, first.Status, reason)
+}
+
+type bulkResponse struct {
+	Took   int                         `json:"took"`
+	Errors bool                        `json:"errors"`
+	Items  []map[string]BulkItemResult `json:"items"`
+}
+
+func newBulkResult(response *bulkResponse) *BulkResult {
+	result := &BulkResult{
+		Took:   response.Took,
+		Errors: response.Errors,
+		Items:  make([]BulkItemResult, 0, len(response.Items)),
+	}
+	for i, item := range response.Items {
+		// 每个 item 只有一个 key，即操作类型
+		for action, itemResult := range item {
+			itemResult.Position = i
+			itemResult.Action = action
+			result.Items = append(result.Items, itemResult)
+		}
+	}
+	return result
+}
+
+// performBulk 发送 bulk 请求并解析每个文档的处理结果，部分文档失败时不返回错误
+func performBulk(ctx context.Context, index string, body []byte, esClient *elasticsearch.Client) (result *BulkResult, err error) {
+	start := time.Now()
+	defer func() {
+		fields := logrus.Fields{}
+		if result != nil {
+			fields["items"] = len(result.Items)
+			fields["failed"] = len(result.Failed())
+		}
+		logRequest("Bulk", index, start, body, fields, err)
+	}()
+
+	res, err := performWithRetry(ctx, func() (*esapi.Response, error) {
+		req := esapi.BulkRequest{
+			Index:   index,
+			Body:    bytes.NewReader(body),
+			Refresh: "false",
+			Pretty:  false,
+		}
+		return req.Do(ctx, esClient)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var response bulkResponse
+	if err := decodeResponse(res, &response); err != nil {
+		return nil, err
+	}
+	return newBulkResult(&response), nil
+}
+
+// bulkErrorOf 有文档处理失败时返回 *BulkError，position 为这些文档在整批中的偏移
+func bulkErrorOf(result *BulkResult, offset int) *BulkError {
+	failed := result.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	for i := range failed {
+		failed[i].Position += offset
+	}
+	return &BulkError{Items: failed}
+}
@@ -0,0 +1,320 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/pkg/errors"
+)
+
+var (
+	defaultFlushBytes    = 5 * 1024 * 1024
+	defaultFlushInterval = 30 * time.Second
+)
+
+// BulkIndexerConfig BulkIndexer 配置
+type BulkIndexerConfig struct {
+	Index         string        // 默认索引，BulkIndexerItem.Index 为空时使用
+	NumWorkers    int           // 并发发送请求的 worker 数，默认 runtime.NumCPU()
+	FlushBytes    int           // 单个请求体达到该字节数时发送，默认 5MB
+	FlushItems    int           // 单个请求达到该文档数时发送，默认 5000
+	FlushInterval time.Duration // 距离上次发送超过该时间时发送，默认 30s
+
+	// OnError 整个 bulk 请求失败时回调，此时每个 item 的 OnFailure 也会被调用
+	OnError func(err error)
+}
+
+// BulkIndexerItem 一次 bulk 操作
+type BulkIndexerItem struct {
+	Action     string      // index、create、update、delete
+	Index      string      // 为空时使用 BulkIndexerConfig.Index
+	DocumentID string      // 文档 _id，index 操作时可以为空
+	Body       interface{} // 文档内容，会被 json 序列化；update 操作时为 {"doc": ...}，delete 操作时为空
+
+	// OnSuccess 文档写入成功时回调
+	OnSuccess func(item BulkIndexerItem, result BulkItemResult)
+	// OnFailure 文档写入失败时回调，请求失败时 result 为空，err 不为空
+	OnFailure func(item BulkIndexerItem, result BulkItemResult, err error)
+}
+
+// BulkIndexerStats BulkIndexer 统计信息
+type BulkIndexerStats struct {
+	NumAdded    uint64
+	NumFlushed  uint64
+	NumFailed   uint64
+	NumRequests uint64
+}
+
+// BulkIndexer 增量接收文档，按请求体大小、文档数量或时间间隔分批并发写入 es
+//
+//	bi, err := es.NewBulkIndexer(es.BulkIndexerConfig{Index: index}, esClient)
+//	...
+//	err = bi.Add(ctx, es.BulkIndexerItem{Action: "index", DocumentID: id, Body: document})
+//	...
+//	err = bi.Close(ctx)
+type BulkIndexer struct {
+	// 放在最前面，保证 32 位平台上原子操作的内存对齐
+	stats BulkIndexerStats
+
+	config   BulkIndexerConfig
+	esClient *elasticsearch.Client
+	queue    chan *bulkIndexerItem
+	wg       sync.WaitGroup
+	mu       sync.RWMutex
+	closed   bool
+	stopped  int32 // 见 stop
+
+	// ctx 用于所有 bulk 请求以及重试等待，Close 超时时取消，未发送的文档以 ctx 的错误失败
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type bulkIndexerItem struct {
+	BulkIndexerItem
+	payload []byte
+}
+
+// NewBulkIndexer 创建 BulkIndexer 并启动 worker，使用完毕后必须调用 Close
+func NewBulkIndexer(config BulkIndexerConfig, esClient *elasticsearch.Client) (*BulkIndexer, error) {
+	if esClient == nil {
+		return nil, errors.New("esClient can not be nil")
+	}
+	if config.NumWorkers <= 0 {
+		config.NumWorkers = runtime.NumCPU()
+	}
+	if config.FlushBytes <= 0 {
+		config.FlushBytes = defaultFlushBytes
+	}
+	if config.FlushItems <= 0 {
+		config.FlushItems = batchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultFlushInterval
+	}
+
+	bi := &BulkIndexer{
+		config:   config,
+		esClient: esClient,
+		queue:    make(chan *bulkIndexerItem, config.NumWorkers),
+	}
+	bi.ctx, bi.cancel = context.WithCancel(context.Background())
+	bi.wg.Add(config.NumWorkers)
+	for i := 0; i < config.NumWorkers; i++ {
+		w := &bulkWorker{bi: bi}
+		go w.run()
+	}
+	return bi, nil
+}
+
+// Add 添加一个文档，文档在 Add 时序列化，序列化失败时直接返回错误
+func (bi *BulkIndexer) Add(ctx context.Context, item BulkIndexerItem) error {
+	payload, err := encodeBulkItem(item, bi.config.Index)
+	if err != nil {
+		return err
+	}
+
+	bi.mu.RLock()
+	defer bi.mu.RUnlock()
+	if bi.closed {
+		return errors.New("bulk indexer is closed")
+	}
+
+	select {
+	case bi.queue <- &bulkIndexerItem{BulkIndexerItem: item, payload: payload}:
+		atomic.AddUint64(&bi.stats.NumAdded, 1)
+		return nil
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// Close 停止接收文档，并等待所有已添加的文档发送完成
+// ctx 结束时取消正在进行的请求，尚未发送的文档通过 OnFailure 报告失败，Close 返回 ctx 的错误
+func (bi *BulkIndexer) Close(ctx context.Context) error {
+	bi.mu.Lock()
+	if !bi.closed {
+		bi.closed = true
+		close(bi.queue)
+	}
+	bi.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		bi.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		bi.cancel()
+		return nil
+	case <-ctx.Done():
+		bi.cancel()
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// stop 之后不再发送新的 bulk 请求，尚未发送的文档通过 OnFailure 报告失败，正在发送的请求不受影响
+func (bi *BulkIndexer) stop() {
+	atomic.StoreInt32(&bi.stopped, 1)
+}
+
+// drain 停止接收文档并等待 worker 退出，不取消正在发送的请求：
+// es 收到的 bulk 请求不会因为连接断开而中止，drain 返回后不会再有文档被写入
+func (bi *BulkIndexer) drain() {
+	bi.mu.Lock()
+	if !bi.closed {
+		bi.closed = true
+		close(bi.queue)
+	}
+	bi.mu.Unlock()
+
+	bi.wg.Wait()
+	bi.cancel()
+}
+
+// Stats 返回统计信息
+func (bi *BulkIndexer) Stats() BulkIndexerStats {
+	return BulkIndexerStats{
+		NumAdded:    atomic.LoadUint64(&bi.stats.NumAdded),
+		NumFlushed:  atomic.LoadUint64(&bi.stats.NumFlushed),
+		NumFailed:   atomic.LoadUint64(&bi.stats.NumFailed),
+		NumRequests: atomic.LoadUint64(&bi.stats.NumRequests),
+	}
+}
+
+type bulkWorker struct {
+	bi    *BulkIndexer
+	buf   bytes.Buffer
+	items []*bulkIndexerItem
+}
+
+func (w *bulkWorker) run() {
+	defer w.bi.wg.Done()
+
+	ticker := time.NewTicker(w.bi.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case item, ok := <-w.bi.queue:
+			if !ok {
+				w.flush()
+				return
+			}
+			// 加入当前文档会超出大小限制时，先发送已有的文档
+			if len(w.items) > 0 && w.buf.Len()+len(item.payload) > w.bi.config.FlushBytes {
+				w.flush()
+			}
+			w.buf.Write(item.payload)
+			w.items = append(w.items, item)
+			if len(w.items) >= w.bi.config.FlushItems || w.buf.Len() >= w.bi.config.FlushBytes {
+				w.flush()
+			}
+		case <-ticker.C:
+			w.flush()
+		}
+	}
+}
+
+func (w *bulkWorker) flush() {
+	if len(w.items) == 0 {
+		return
+	}
+	defer func() {
+		w.buf.Reset()
+		w.items = w.items[:0]
+	}()
+
+	bi := w.bi
+
+	var (
+		result *BulkResult
+		err    error
+	)
+	if atomic.LoadInt32(&bi.stopped) == 1 {
+		err = errors.New("bulk indexer is stopped")
+	} else {
+		atomic.AddUint64(&bi.stats.NumRequests, 1)
+		result, err = w.send()
+	}
+	if err != nil {
+		if bi.config.OnError != nil {
+			bi.config.OnError(err)
+		}
+		for _, item := range w.items {
+			atomic.AddUint64(&bi.stats.NumFailed, 1)
+			if item.OnFailure != nil {
+				item.OnFailure(item.BulkIndexerItem, BulkItemResult{}, err)
+			}
+		}
+		return
+	}
+
+	for i, item := range w.items {
+		var itemResult BulkItemResult
+		if i < len(result.Items) {
+			itemResult = result.Items[i]
+		}
+		if itemResult.Failed() {
+			atomic.AddUint64(&bi.stats.NumFailed, 1)
+			if item.OnFailure != nil {
+				item.OnFailure(item.BulkIndexerItem, itemResult, nil)
+			}
+			continue
+		}
+		atomic.AddUint64(&bi.stats.NumFlushed, 1)
+		if item.OnSuccess != nil {
+			item.OnSuccess(item.BulkIndexerItem, itemResult)
+		}
+	}
+}
+
+func (w *bulkWorker) send() (*BulkResult, error) {
+	payloads := make([][]byte, len(w.items))
+	for i, item := range w.items {
+		payloads[i] = item.payload
+	}
+	return performBulkWithRetry(w.bi.ctx, w.bi.config.Index, payloads, w.bi.esClient)
+}
+
+// encodeBulkItem 生成一个文档对应的 NDJSON 内容，包含操作行以及文档行
+func encodeBulkItem(item BulkIndexerItem, defaultIndex string) ([]byte, error) {
+	switch item.Action {
+	case "index", "create", "update", "delete":
+	default:
+		return nil, errors.Errorf("unsupported bulk action %q", item.Action)
+	}
+
+	meta := map[string]interface{}{}
+	if item.Index != "" && item.Index != defaultIndex {
+		meta["_index"] = item.Index
+	}
+	if item.DocumentID != "" {
+		meta["_id"] = item.DocumentID
+	}
+
+	var buf bytes.Buffer
+	header, err := json.Marshal(map[string]interface{}{item.Action: meta})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	buf.Write(header)
+	buf.WriteByte('\n')
+
+	if item.Action == "delete" {
+		return buf.Bytes(), nil
+	}
+	content, err := json.Marshal(item.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "encode document %s failed", item.DocumentID)
+	}
+	buf.Write(content)
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,155 @@
+package es
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestBulkIndexer(t *testing.T) {
+	server, esClient := newTestServer(t)
+	defer server.Close()
+
+	bi, err := NewBulkIndexer(BulkIndexerConfig{Index: "products", NumWorkers: 4, FlushItems: 10}, esClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu        sync.Mutex
+		succeeded int
+	)
+	onSuccess := func(BulkIndexerItem, BulkItemResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		succeeded++
+	}
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		err := bi.Add(ctx, BulkIndexerItem{
+			Action:     "index",
+			DocumentID: fmt.Sprint(i),
+			Body:       testProduct{ID: fmt.Sprint(i), Name: "product"},
+			OnSuccess:  onSuccess,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bi.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := bi.Stats()
+	if stats.NumAdded != 100 || stats.NumFlushed != 100 || stats.NumFailed != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats.NumRequests < 10 {
+		t.Fatalf("expected at least 10 requests, got %d", stats.NumRequests)
+	}
+	if succeeded != 100 {
+		t.Fatalf("expected 100 OnSuccess calls, got %d", succeeded)
+	}
+	if n := len(server.Documents("products")); n != 100 {
+		t.Fatalf("expected 100 documents, got %d", n)
+	}
+	if err := bi.Add(ctx, BulkIndexerItem{Action: "index", Body: testProduct{}}); err == nil {
+		t.Fatal("expected error when adding to a closed indexer")
+	}
+}
+
+func TestBulkIndexerFailure(t *testing.T) {
+	server, esClient := newTestServer(t)
+	defer server.Close()
+
+	if err := server.Put("products", "1", testProduct{ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	bi, err := NewBulkIndexer(BulkIndexerConfig{Index: "products", NumWorkers: 1}, esClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var failed []BulkItemResult
+	onFailure := func(item BulkIndexerItem, result BulkItemResult, err error) {
+		if err != nil {
+			t.Errorf("unexpected request error %v", err)
+		}
+		failed = append(failed, result)
+	}
+	ctx := context.Background()
+	for _, id := range []string{"1", "2"} {
+		err := bi.Add(ctx, BulkIndexerItem{Action: "create", DocumentID: id, Body: testProduct{ID: id}, OnFailure: onFailure})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bi.Add(ctx, BulkIndexerItem{Action: "upsert"}); err == nil {
+		t.Fatal("expected error for unsupported action")
+	}
+	if err := bi.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(failed) != 1 || failed[0].ID != "1" || failed[0].Status != 409 {
+		t.Fatalf("unexpected failures %+v", failed)
+	}
+	if stats := bi.Stats(); stats.NumFlushed != 1 || stats.NumFailed != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBulkIndexerCloseCancel(t *testing.T) {
+	var requests int32
+	server, esClient := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		// 读完请求体后服务端才能感知到连接断开；模拟繁忙的集群，直到请求被取消
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer server.Close()
+
+	bi, err := NewBulkIndexer(BulkIndexerConfig{Index: "products", NumWorkers: 1, FlushItems: 1}, esClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed int32
+	onFailure := func(BulkIndexerItem, BulkItemResult, error) {
+		atomic.AddInt32(&failed, 1)
+	}
+	for i := 0; i < 2; i++ {
+		err := bi.Add(context.Background(), BulkIndexerItem{Action: "index", Body: testProduct{}, OnFailure: onFailure})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := bi.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	sent := atomic.LoadInt32(&requests)
+
+	// 取消后 worker 很快退出，剩余的文档不再发送
+	bi.wg.Wait()
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&requests); n != sent || n > 1 {
+		t.Fatalf("expected no bulk request after Close, got %d before and %d after", sent, n)
+	}
+	if n := atomic.LoadInt32(&failed); n != 2 {
+		t.Fatalf("expected 2 failures, got %d", n)
+	}
+}
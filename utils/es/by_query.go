@@ -0,0 +1,178 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/pkg/errors"
+)
+
+// SlicesAuto 由 es 根据分片数决定切片数
+const SlicesAuto = -1
+
+// ByQueryOptions DeleteByQuery、UpdateByQuery 配置
+type ByQueryOptions struct {
+	// Query 查询语句中的 query 部分；UpdateByQuery 为空时匹配所有文档，
+	// DeleteByQuery 必须指定，避免误删所有文档，需要删除所有文档时显式使用 {"match_all": {}}
+	Query map[string]interface{}
+	// Conflicts 遇到版本冲突时的处理方式：abort（默认）中止任务，proceed 跳过冲突的文档继续执行
+	Conflicts string
+	// Slices 并发切片数，0 表示不切片，SlicesAuto 表示由 es 决定
+	Slices int
+	// RequestsPerSecond 每秒处理的文档数，0 表示不限流
+	RequestsPerSecond int
+	// MaxDocs 最多处理的文档数，0 表示不限制
+	MaxDocs int
+	// Refresh 任务完成后刷新索引
+	Refresh bool
+	// PollInterval 查询任务进度的间隔，默认 5s
+	PollInterval time.Duration
+	// OnProgress 任务进度回调
+	OnProgress func(status TaskStatus)
+}
+
+// Script painless 脚本
+//
+//	script := &es.Script{
+//		Source: "ctx._source.count += params.delta",
+//		Params: map[string]interface{}{"delta": 1},
+//	}
+type Script struct {
+	Source string                 `json:"source"`
+	Lang   string                 `json:"lang,omitempty"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// DeleteByQuery 删除 index 中满足条件的文档，任务在后台执行，等待任务完成后返回结果
+// 有失败的文档时返回 *TaskError
+func DeleteByQuery(ctx context.Context, index string, opts ByQueryOptions, esClient *elasticsearch.Client) (*TaskResult, error) {
+	taskID, err := StartDeleteByQuery(ctx, index, opts, esClient)
+	if err != nil {
+		return nil, err
+	}
+	return WaitForTask(ctx, taskID, opts.PollInterval, progressCallback(opts.OnProgress), esClient)
+}
+
+// StartDeleteByQuery 提交 delete by query 任务后立即返回任务 ID，通过 GetTask、WaitForTask 查询进度
+func StartDeleteByQuery(ctx context.Context, index string, opts ByQueryOptions, esClient *elasticsearch.Client) (string, error) {
+	if len(opts.Query) == 0 {
+		return "", errors.New(`delete by query requires a query, use {"match_all": {}} to delete all documents`)
+	}
+	reqBody, err := byQueryBody(opts, nil)
+	if err != nil {
+		return "", err
+	}
+	waitForCompletion := false
+	taskID, err := startTask(ctx, esClient, func() esapi.Request {
+		req := esapi.DeleteByQueryRequest{
+			Index:             []string{index},
+			Body:              bodyReader(reqBody),
+			Conflicts:         opts.Conflicts,
+			WaitForCompletion: &waitForCompletion,
+		}
+		req.Slices, req.RequestsPerSecond, req.MaxDocs, req.Refresh = byQueryParams(opts)
+		return req
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "start delete by query failed")
+	}
+	return taskID, nil
+}
+
+// UpdateByQuery 使用 script 更新 index 中满足条件的文档，任务在后台执行，等待任务完成后返回结果
+// script 为空时只重新索引文档，用于新增 mapping 字段后使已有文档生效
+func UpdateByQuery(ctx context.Context, index string, script *Script, opts ByQueryOptions, esClient *elasticsearch.Client) (*TaskResult, error) {
+	taskID, err := StartUpdateByQuery(ctx, index, script, opts, esClient)
+	if err != nil {
+		return nil, err
+	}
+	return WaitForTask(ctx, taskID, opts.PollInterval, progressCallback(opts.OnProgress), esClient)
+}
+
+// StartUpdateByQuery 提交 update by query 任务后立即返回任务 ID，通过 GetTask、WaitForTask 查询进度
+func StartUpdateByQuery(ctx context.Context, index string, script *Script, opts ByQueryOptions, esClient *elasticsearch.Client) (string, error) {
+	reqBody, err := byQueryBody(opts, script)
+	if err != nil {
+		return "", err
+	}
+	waitForCompletion := false
+	taskID, err := startTask(ctx, esClient, func() esapi.Request {
+		req := esapi.UpdateByQueryRequest{
+			Index:             []string{index},
+			Body:              bodyReader(reqBody),
+			Conflicts:         opts.Conflicts,
+			WaitForCompletion: &waitForCompletion,
+		}
+		req.Slices, req.RequestsPerSecond, req.MaxDocs, req.Refresh = byQueryParams(opts)
+		return req
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "start update by query failed")
+	}
+	return taskID, nil
+}
+
+// RethrottleDeleteByQuery 修改正在执行的 delete by query 任务的限流，requestsPerSecond 为 -1 表示不限流
+func RethrottleDeleteByQuery(ctx context.Context, taskID string, requestsPerSecond int, esClient *elasticsearch.Client) error {
+	return doRequest(ctx, esClient, nil, func() esapi.Request {
+		return esapi.DeleteByQueryRethrottleRequest{TaskID: taskID, RequestsPerSecond: &requestsPerSecond}
+	})
+}
+
+// RethrottleUpdateByQuery 修改正在执行的 update by query 任务的限流，requestsPerSecond 为 -1 表示不限流
+func RethrottleUpdateByQuery(ctx context.Context, taskID string, requestsPerSecond int, esClient *elasticsearch.Client) error {
+	return doRequest(ctx, esClient, nil, func() esapi.Request {
+		return esapi.UpdateByQueryRethrottleRequest{TaskID: taskID, RequestsPerSecond: &requestsPerSecond}
+	})
+}
+
+func byQueryBody(opts ByQueryOptions, script *Script) ([]byte, error) {
+	body := map[string]interface{}{}
+	if opts.Query != nil {
+		body["query"] = opts.Query
+	}
+	if script != nil {
+		body["script"] = script
+	}
+	if len(body) == 0 {
+		return nil, nil
+	}
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return reqBody, nil
+}
+
+// byQueryParams 返回请求的 slices、requests_per_second、max_docs、refresh 参数，零值表示使用 es 的默认值
+func byQueryParams(opts ByQueryOptions) (slices interface{}, requestsPerSecond *int, maxDocs *int, refresh *bool) {
+	switch {
+	case opts.Slices == SlicesAuto:
+		slices = "auto"
+	case opts.Slices > 0:
+		slices = opts.Slices
+	}
+	if opts.RequestsPerSecond > 0 {
+		requestsPerSecond = &opts.RequestsPerSecond
+	}
+	if opts.MaxDocs > 0 {
+		maxDocs = &opts.MaxDocs
+	}
+	if opts.Refresh {
+		refresh = &opts.Refresh
+	}
+	return
+}
+
+// progressCallback 将 TaskStatus 回调转换为 WaitForTask 的回调
+func progressCallback(onProgress func(TaskStatus)) func(*TaskResult) {
+	if onProgress == nil {
+		return nil
+	}
+	return func(task *TaskResult) {
+		onProgress(task.Status)
+	}
+}
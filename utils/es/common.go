@@ -4,241 +4,247 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"log"
-	"reflect"
-	"strings"
 
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
 var batchSize = 5000
 
 // PerformESInsert 执行 es 批量 insert 操作
-// TODO: documents 数量达到一定程度时分批插入
+// 文档的 _id、routing 由 es struct tag 或 Identifiable 接口指定，见 documentMeta
+// 按文档数量以及请求体大小分批发送，见 performBulkBatches
 func PerformESInsert(index string, documents []interface{}, esClient *elasticsearch.Client) error {
+	return PerformESInsertContext(context.Background(), index, documents, esClient)
+}
+
+// PerformESInsertContext 同 PerformESInsert，ctx 取消或超时时中止请求
+func PerformESInsertContext(ctx context.Context, index string, documents []interface{}, esClient *elasticsearch.Client) error {
 	if len(documents) == 0 {
-		log.Println("documents is empty")
 		return nil
 	}
-	requestBody, err := getInsertRequestBody(index, documents)
+	items, err := getInsertRequestItems(index, documents)
 	if err != nil {
-		log.Printf("Error getting request body: %s", err)
 		return err
 	}
-	return PerformESBulk(index, requestBody, esClient)
+	return performBulkBatches(ctx, index, items, esClient)
 }
 
 // PerformESUpsert 执行 es 批量 upsert 操作
 // update 时是按字段更新
-// TODO: documents 数量达到一定程度时分批插入
+// 按文档数量以及请求体大小分批发送，见 performBulkBatches
 func PerformESUpsert(index string, documents []interface{}, esClient *elasticsearch.Client) error {
-	requestBody, err := getUpsertRequestBody(index, documents)
+	return PerformESUpsertContext(context.Background(), index, documents, esClient)
+}
+
+// PerformESUpsertContext 同 PerformESUpsert，ctx 取消或超时时中止请求
+func PerformESUpsertContext(ctx context.Context, index string, documents []interface{}, esClient *elasticsearch.Client) error {
+	items, err := getUpsertRequestItems(index, documents)
 	if err != nil {
-		log.Printf("Error getting request body: %s", err)
 		return err
 	}
-	return PerformESBulk(index, requestBody, esClient)
+	return performBulkBatches(ctx, index, items, esClient)
 }
 
 // PerformESIndex Indexes the specified document. If the document exists, replaces the document and increments the version.
+// If the document has an `es:"version"` field, external versioning is used.
 func PerformESIndex(index string, documents []interface{}, esClient *elasticsearch.Client) error {
-	requestBody, err := getIndexRequestBody(index, documents)
+	return PerformESIndexContext(context.Background(), index, documents, esClient)
+}
+
+// PerformESIndexContext 同 PerformESIndex，ctx 取消或超时时中止请求
+func PerformESIndexContext(ctx context.Context, index string, documents []interface{}, esClient *elasticsearch.Client) error {
+	items, err := getIndexRequestItems(index, documents)
 	if err != nil {
-		log.Printf("Error getting request body: %s", err)
 		return err
 	}
-	return PerformESBulk(index, requestBody, esClient)
+	return performBulkBatches(ctx, index, items, esClient)
 }
 
 // DeleteESIndex ...
 func DeleteESIndex(index string, esClient *elasticsearch.Client) error {
-	log.Print("delete ES all documents. Collection: ", index)
-
-	indexes := []string{index}
-	req := esapi.IndicesDeleteRequest{
-		Index: indexes,
-	}
-
-	// Perform the request with the client.
-	res, err := req.Do(context.Background(), esClient)
-	if err != nil {
-		log.Printf("delete ES all documents, error getting response: %s", err)
-		return err
-	}
-	defer res.Body.Close()
-
-	if res.IsError() {
-		log.Printf("delete ES all documents, [%s] error indexing document", res.Status())
-		return err
-	}
-
-	log.Print("delete ES all documents. Collection: ", index, ". Done")
+	return DeleteESIndexContext(context.Background(), index, esClient)
+}
 
-	return nil
+// DeleteESIndexContext 同 DeleteESIndex，ctx 取消或超时时中止请求
+func DeleteESIndexContext(ctx context.Context, index string, esClient *elasticsearch.Client) error {
+	return doRequest(ctx, esClient, nil, func() esapi.Request {
+		return esapi.IndicesDeleteRequest{
+			Index: []string{index},
+		}
+	})
 }
 
 // PerformESDelete 执行 es 批量 delete 操作
+// 部分文档删除失败时继续处理后续批次，最后返回汇总了所有失败文档的 *BulkError
 func PerformESDelete(index string, ids []string, esClient *elasticsearch.Client) error {
+	return PerformESDeleteContext(context.Background(), index, ids, esClient)
+}
 
-	log.Print("perform es document delete. Index: ", index, ". Total: ", len(ids))
-	for i := 0; i < len(ids); i += batchSize {
-		endIndex := i + batchSize
-		if endIndex > len(ids) {
-			endIndex = len(ids)
-		}
-		var bodyBuf bytes.Buffer
-		for _, id := range ids[i:endIndex] {
-			deleteHeader :=
-				map[string]interface{}{
-					"delete": map[string]interface{}{
-						"_index": index,
-						"_id":    id,
-					},
-				}
-			header, err := json.Marshal(deleteHeader)
-			if err != nil {
-				log.Fatal(err)
-				return err
+// PerformESDeleteContext 同 PerformESDelete，ctx 取消或超时时中止后续批次
+// bulk 请求本身失败时返回包含批次范围的错误，可以用 IsTooManyRequests 等判断原因
+func PerformESDeleteContext(ctx context.Context, index string, ids []string, esClient *elasticsearch.Client) error {
+	currentLogger().WithFields(logrus.Fields{"index": index, "total": len(ids)}).Debug("es delete documents")
+	items := make([][]byte, 0, len(ids))
+	for _, id := range ids {
+		deleteHeader :=
+			map[string]interface{}{
+				"delete": map[string]interface{}{
+					"_index": index,
+					"_id":    id,
+				},
 			}
-			bodyBuf.Write(header)
-			bodyBuf.WriteByte('\n')
-		}
-		err := PerformESBulk(index, bodyBuf.String(), esClient)
+		item, err := encodeBulkLines(deleteHeader, nil)
 		if err != nil {
-			log.Fatal(err)
-			return err
+			return errors.Wrapf(err, "encode delete header of %s failed", id)
 		}
+		items = append(items, item)
 	}
+	return performBulkBatches(ctx, index, items, esClient)
+}
 
+// performBulkBatches 按 batchSize 文档数以及 defaultFlushBytes 请求体大小分批发送 items，
+// 部分文档失败时继续发送后续批次，最后返回汇总了所有失败文档的 *BulkError，Position 为文档在 items 中的序号
+func performBulkBatches(ctx context.Context, index string, items [][]byte, esClient *elasticsearch.Client) error {
+	var failed []BulkItemResult
+	for start := 0; start < len(items); {
+		end, size := start, 0
+		for end < len(items) && end-start < batchSize &&
+			(end == start || size+len(items[end]) <= defaultFlushBytes) {
+			size += len(items[end])
+			end++
+		}
+		result, err := performBulkWithRetry(ctx, index, items[start:end], esClient)
+		if err != nil {
+			return errors.Wrapf(err, "bulk documents %d-%d failed", start, end)
+		}
+		if bulkErr := bulkErrorOf(result, start); bulkErr != nil {
+			failed = append(failed, bulkErr.Items...)
+		}
+		start = end
+	}
+	if len(failed) > 0 {
+		return &BulkError{Items: failed}
+	}
 	return nil
 }
 
-// 组装 es 批量插入语句
-func getInsertRequestBody(index string, documents []interface{}) (string, error) {
-	var bodyBuf bytes.Buffer
+// encodeBulkLines 生成一个文档的操作行以及文档行，body 为空时只有操作行
+func encodeBulkLines(header interface{}, body interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	line, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(line)
+	buf.WriteByte('\n')
+	if body == nil {
+		return buf.Bytes(), nil
+	}
+	content, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(content)
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// 组装 es 批量插入语句，每个文档一项
+func getInsertRequestItems(index string, documents []interface{}) ([][]byte, error) {
+	items := make([][]byte, 0, len(documents))
 	for _, document := range documents {
-		documentValue := reflect.ValueOf(document).Elem()
+		meta, err := documentMetaOf(document)
+		if err != nil {
+			return nil, err
+		}
+		createMeta := bulkHeader("create", index, meta)
+		createMeta["_type"] = "_doc"
 		createHeader :=
 			map[string]interface{}{
-				"create": map[string]interface{}{
-					"_index": index,
-					"_id":    documentValue.FieldByName("ID").String(),
-					"_type":  "_doc",
-				},
+				"create": createMeta,
 			}
-		header, err := json.Marshal(createHeader)
-		if err != nil {
-			// TODO: LOG
-			return "", err
-		}
-		bodyBuf.Write(header)
-		bodyBuf.WriteByte('\n')
-		content, err := json.Marshal(document)
+		item, err := encodeBulkLines(createHeader, document)
 		if err != nil {
-			// TODO: LOG
-			return "", err
+			return nil, err
 		}
-		bodyBuf.Write(content)
-		bodyBuf.WriteByte('\n')
+		items = append(items, item)
 	}
-	return bodyBuf.String(), nil
+	return items, nil
 }
 
-func getUpsertRequestBody(index string, documents []interface{}) (string, error) {
-	var bodyBuf bytes.Buffer
+func getUpsertRequestItems(index string, documents []interface{}) ([][]byte, error) {
+	items := make([][]byte, 0, len(documents))
 	for _, document := range documents {
-		documentValue := reflect.ValueOf(document).Elem()
+		meta, err := documentMetaOf(document)
+		if err != nil {
+			return nil, err
+		}
+		upsertMeta := bulkHeader("update", index, meta)
+		upsertMeta["_type"] = "_doc"
+		// 失败重试 3 次
+		upsertMeta["retry_on_conflict"] = 3
 		upsertHeader :=
 			map[string]interface{}{
-				"update": map[string]interface{}{
-					"_index": index,
-					"_id":    documentValue.FieldByName("ID").String(),
-					"_type":  "_doc",
-					// 失败重试 3 次
-					"retry_on_conflict": 3,
-				},
+				"update": upsertMeta,
 			}
-		header, err := json.Marshal(upsertHeader)
-		if err != nil {
-			// TODO: LOG
-			return "", err
-		}
-		bodyBuf.Write(header)
-		bodyBuf.WriteByte('\n')
-
 		upsertBody :=
 			map[string]interface{}{
 				"doc":           document,
 				"doc_as_upsert": true,
 			}
-		content, err := json.Marshal(upsertBody)
+		item, err := encodeBulkLines(upsertHeader, upsertBody)
 		if err != nil {
-			// TODO: LOG
-			return "", err
+			return nil, err
 		}
-		bodyBuf.Write(content)
-		bodyBuf.WriteByte('\n')
+		items = append(items, item)
 	}
-	return bodyBuf.String(), nil
+	return items, nil
 }
 
 // 详见： https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-index_.html
-func getIndexRequestBody(index string, documents []interface{}) (string, error) {
-	var bodyBuf bytes.Buffer
+func getIndexRequestItems(index string, documents []interface{}) ([][]byte, error) {
+	items := make([][]byte, 0, len(documents))
 	for _, document := range documents {
-		documentValue := reflect.ValueOf(document).Elem()
+		meta, err := documentMetaOf(document)
+		if err != nil {
+			return nil, err
+		}
 		indexHeader :=
 			map[string]interface{}{
-				"index": map[string]interface{}{
-					"_index": index,
-					"_id":    documentValue.FieldByName("ID").String(),
-				},
+				"index": bulkHeader("index", index, meta),
 			}
-		header, err := json.Marshal(indexHeader)
+		item, err := encodeBulkLines(indexHeader, document)
 		if err != nil {
-			// TODO: LOG
-			return "", err
+			return nil, err
 		}
-		bodyBuf.Write(header)
-		bodyBuf.WriteByte('\n')
-		content, err := json.Marshal(document)
-		if err != nil {
-			// TODO: LOG
-			return "", err
-		}
-		bodyBuf.Write(content)
-		bodyBuf.WriteByte('\n')
+		items = append(items, item)
 	}
-	return bodyBuf.String(), nil
+	return items, nil
 }
 
 // PerformESBulk ES 批量操作接口
+// 返回每个文档的处理结果，部分文档处理失败时同时返回 *BulkError
+// 集群繁忙时按 RetryPolicy 只重试失败的文档
 // 详见：https://www.elastic.co/guide/en/elasticsearch/reference/master/docs-bulk.html
-func PerformESBulk(index string, requestBody string, esClient *elasticsearch.Client) error {
-	// Set up the request object.
-	req := esapi.BulkRequest{
-		Index:   index,
-		Body:    strings.NewReader(requestBody),
-		Refresh: "false",
-		Pretty:  false,
-	}
+func PerformESBulk(index string, requestBody string, esClient *elasticsearch.Client) (*BulkResult, error) {
+	return PerformESBulkContext(context.Background(), index, requestBody, esClient)
+}
 
-	// Perform the request with the client.
-	res, err := req.Do(context.Background(), esClient)
+// PerformESBulkContext 同 PerformESBulk，ctx 取消或超时时中止请求以及重试
+func PerformESBulkContext(ctx context.Context, index string, requestBody string, esClient *elasticsearch.Client) (*BulkResult, error) {
+	items, err := splitBulkBody([]byte(requestBody))
 	if err != nil {
-		log.Printf("Error getting response: %s", err)
-		return err
+		return nil, err
 	}
-	defer res.Body.Close()
-	if res.IsError() {
-		log.Printf("[%s] Error indexing document", res.Status())
-		return err
+	result, err := performBulkWithRetry(ctx, index, items, esClient)
+	if err != nil {
+		return nil, err
 	}
-	// Deserialize the response into a map.
-	var r map[string]interface{}
-	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
-		log.Printf("Error parsing the response body: %s", err)
+	if bulkErr := bulkErrorOf(result, 0); bulkErr != nil {
+		return result, bulkErr
 	}
-	return nil
+	return result, nil
 }
@@ -0,0 +1,167 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/pkg/errors"
+	"github.com/yzlq99/go_utils/utils/es/estest"
+)
+
+type testProduct struct {
+	ID    string  `json:"id" es:"id"`
+	Name  string  `json:"name"`
+	Price float64 `json:"price,omitempty"`
+}
+
+func newTestServer(t *testing.T) (*estest.Server, *elasticsearch.Client) {
+	t.Helper()
+	server := estest.NewServer()
+	return server, server.Client()
+}
+
+// newTestClient 返回连接到 handler 的客户端，用于模拟 estest 不支持的响应，客户端不会重试失败的请求
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *elasticsearch.Client) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	esClient, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses:    []string{server.URL},
+		DisableRetry: true,
+	})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return server, esClient
+}
+
+func testProducts(n int) []interface{} {
+	documents := make([]interface{}, 0, n)
+	for i := 1; i <= n; i++ {
+		documents = append(documents, testProduct{ID: fmt.Sprint(i), Name: fmt.Sprintf("product %d", i), Price: float64(i)})
+	}
+	return documents
+}
+
+func decodeProduct(t *testing.T, source json.RawMessage) testProduct {
+	t.Helper()
+	var product testProduct
+	if err := json.Unmarshal(source, &product); err != nil {
+		t.Fatal(err)
+	}
+	return product
+}
+
+func TestPerformESInsert(t *testing.T) {
+	server, esClient := newTestServer(t)
+	defer server.Close()
+
+	if err := PerformESInsert("products", testProducts(3), esClient); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(server.Documents("products")); n != 3 {
+		t.Fatalf("expected 3 documents, got %d", n)
+	}
+
+	// create 已存在的文档失败，其他文档不受影响
+	documents := []interface{}{testProduct{ID: "4", Name: "new"}, testProduct{ID: "2", Name: "duplicate"}}
+	err := PerformESInsert("products", documents, esClient)
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) {
+		t.Fatalf("expected *BulkError, got %v", err)
+	}
+	if len(bulkErr.Items) != 1 || bulkErr.Items[0].Position != 1 || bulkErr.Items[0].Status != 409 {
+		t.Fatalf("unexpected failed items %+v", bulkErr.Items)
+	}
+	if name := decodeProduct(t, server.Documents("products")["2"]).Name; name != "product 2" {
+		t.Fatalf("document 2 should not be overwritten, got %q", name)
+	}
+	if n := len(server.Documents("products")); n != 4 {
+		t.Fatalf("expected 4 documents, got %d", n)
+	}
+}
+
+func TestPerformESInsertBatches(t *testing.T) {
+	server, esClient := newTestServer(t)
+	defer server.Close()
+
+	old := batchSize
+	batchSize = 2
+	defer func() { batchSize = old }()
+
+	documents := append(testProducts(5), testProduct{ID: "1", Name: "duplicate"})
+	err := PerformESInsert("products", documents, esClient)
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) {
+		t.Fatalf("expected *BulkError, got %v", err)
+	}
+	// Position 为文档在整个 documents 中的序号，而不是在批次中的序号
+	if len(bulkErr.Items) != 1 || bulkErr.Items[0].Position != 5 {
+		t.Fatalf("unexpected failed items %+v", bulkErr.Items)
+	}
+	if n := len(server.Documents("products")); n != 5 {
+		t.Fatalf("expected 5 documents, got %d", n)
+	}
+}
+
+func TestPerformESUpsert(t *testing.T) {
+	server, esClient := newTestServer(t)
+	defer server.Close()
+
+	if err := PerformESInsert("products", testProducts(2), esClient); err != nil {
+		t.Fatal(err)
+	}
+	// 按字段更新，没有设置的 price 保留原值
+	documents := []interface{}{testProduct{ID: "1", Name: "renamed"}, testProduct{ID: "3", Name: "created"}}
+	if err := PerformESUpsert("products", documents, esClient); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := server.Documents("products")
+	if len(stored) != 3 {
+		t.Fatalf("expected 3 documents, got %d", len(stored))
+	}
+	if p := decodeProduct(t, stored["1"]); p.Name != "renamed" || p.Price != 1 {
+		t.Fatalf("unexpected document 1 %+v", p)
+	}
+	if p := decodeProduct(t, stored["3"]); p.Name != "created" {
+		t.Fatalf("unexpected document 3 %+v", p)
+	}
+}
+
+func TestPerformESIndex(t *testing.T) {
+	server, esClient := newTestServer(t)
+	defer server.Close()
+
+	if err := PerformESIndex("products", testProducts(2), esClient); err != nil {
+		t.Fatal(err)
+	}
+	// index 替换整个文档
+	if err := PerformESIndex("products", []interface{}{testProduct{ID: "1", Name: "replaced"}}, esClient); err != nil {
+		t.Fatal(err)
+	}
+	if p := decodeProduct(t, server.Documents("products")["1"]); p.Name != "replaced" || p.Price != 0 {
+		t.Fatalf("unexpected document 1 %+v", p)
+	}
+}
+
+func TestPerformESDelete(t *testing.T) {
+	server, esClient := newTestServer(t)
+	defer server.Close()
+
+	if err := PerformESInsert("products", testProducts(3), esClient); err != nil {
+		t.Fatal(err)
+	}
+	// 删除不存在的文档不算失败
+	if err := PerformESDelete("products", []string{"1", "2", "missing"}, esClient); err != nil {
+		t.Fatal(err)
+	}
+	stored := server.Documents("products")
+	if _, ok := stored["3"]; len(stored) != 1 || !ok {
+		t.Fatalf("expected only document 3 left, got %d documents", len(stored))
+	}
+}
@@ -0,0 +1,204 @@
+package es
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Identifiable 文档实现该接口时使用 DocumentID 作为 _id，优先级高于 struct tag
+type Identifiable interface {
+	DocumentID() string
+}
+
+// documentMeta 写入 es 时 bulk 操作行需要的文档元信息
+//
+// 通过 struct tag 声明：
+//
+//	type Document struct {
+//		UID      int64  `json:"uid" es:"id"`
+//		TenantID string `json:"tenant_id" es:"routing"`
+//		Revision int64  `json:"revision" es:"version"`
+//	}
+//
+// 没有 es:"id" tag 时使用名为 ID 的字段，兼容以前的文档定义。ID 为零值（0、空字符串等）时视为缺失
+type documentMeta struct {
+	ID      string
+	Routing string
+	Version *int64
+}
+
+// documentMetaOf 读取文档的 _id、routing 以及 version，document 可以是结构体或结构体指针
+func documentMetaOf(document interface{}) (documentMeta, error) {
+	var meta documentMeta
+	if d, ok := document.(Identifiable); ok {
+		meta.ID = d.DocumentID()
+	}
+
+	v := reflect.ValueOf(document)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return meta, errors.Errorf("document %T is nil", document)
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Struct {
+		fields := make(map[string]reflect.Value)
+		collectMetaFields(v, fields)
+
+		if meta.ID == "" {
+			if f, ok := fields["id"]; ok {
+				meta.ID = documentID(f)
+			} else if f := v.FieldByName("ID"); f.IsValid() {
+				meta.ID = documentID(f)
+			}
+		}
+		if f, ok := fields["routing"]; ok {
+			meta.Routing = formatMetaField(f)
+		}
+		if f, ok := fields["version"]; ok {
+			version, err := versionOf(f)
+			if err != nil {
+				return meta, errors.Wrapf(err, "document %T", document)
+			}
+			meta.Version = version
+		}
+	}
+
+	if meta.ID == "" {
+		return meta, errors.Errorf("no document id found in %T, add an `es:\"id\"` tag, an ID field or implement es.Identifiable, zero values are treated as missing", document)
+	}
+	return meta, nil
+}
+
+// collectMetaFields 收集带有 es:"id"、es:"routing"、es:"version" tag 的字段，包括匿名嵌入结构体中的字段
+func collectMetaFields(v reflect.Value, fields map[string]reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+
+		if field.Anonymous {
+			for value.Kind() == reflect.Ptr {
+				if value.IsNil() {
+					break
+				}
+				value = value.Elem()
+			}
+			if value.Kind() == reflect.Struct {
+				collectMetaFields(value, fields)
+				continue
+			}
+		}
+
+		flags, _ := parseTag(field.Tag.Get("es"))
+		for _, name := range []string{"id", "routing", "version"} {
+			if _, ok := fields[name]; !ok && flags[name] {
+				fields[name] = value
+			}
+		}
+	}
+}
+
+// parseTag 解析 es struct tag，如 `es:"id"`、`es:"type=keyword,index=false"`
+// 不带 = 的部分作为 flag 返回，key=value 形式的部分作为 option 返回
+func parseTag(tag string) (flags map[string]bool, options map[string]string) {
+	flags = make(map[string]bool)
+	options = make(map[string]string)
+	for _, part := range strings.Split(tag, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if i := strings.Index(part, "="); i >= 0 {
+			options[strings.TrimSpace(part[:i])] = strings.TrimSpace(part[i+1:])
+			continue
+		}
+		flags[part] = true
+	}
+	return flags, options
+}
+
+// documentID 零值（如未赋值的数字 ID、空的 ObjectID）视为没有 _id，避免所有文档都写入 _id 为 "0" 的同一个文档
+func documentID(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if v.IsZero() {
+		return ""
+	}
+	return formatMetaField(v)
+}
+
+func formatMetaField(v reflect.Value) string {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if v.CanInterface() {
+		// 如 mongo 的 primitive.ObjectID
+		if h, ok := v.Interface().(interface{ Hex() string }); ok {
+			return h.Hex()
+		}
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	}
+	if v.CanInterface() {
+		if s, ok := v.Interface().(fmt.Stringer); ok {
+			return s.String()
+		}
+	}
+	return ""
+}
+
+func versionOf(v reflect.Value) (*int64, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	var version int64
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		version = v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		version = int64(v.Uint())
+	default:
+		return nil, errors.Errorf("version field must be an integer, got %s", v.Type())
+	}
+	return &version, nil
+}
+
+// bulkHeader 生成 bulk 操作行，external version 只对 index 操作生效
+func bulkHeader(action string, index string, meta documentMeta) map[string]interface{} {
+	header := map[string]interface{}{
+		"_index": index,
+		"_id":    meta.ID,
+	}
+	if meta.Routing != "" {
+		header["routing"] = meta.Routing
+	}
+	if meta.Version != nil && action == "index" {
+		header["version"] = *meta.Version
+		header["version_type"] = "external"
+	}
+	return header
+}
@@ -0,0 +1,159 @@
+package es
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type taggedDocument struct {
+	UID      int64  `json:"uid" es:"id"`
+	TenantID string `json:"tenant_id" es:"routing"`
+	Revision int64  `json:"revision" es:"version"`
+}
+
+type legacyIDDocument struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type identifiableDocument struct {
+	ID   string `json:"id" es:"id"`
+	Code string `json:"code"`
+}
+
+func (d identifiableDocument) DocumentID() string {
+	return "code-" + d.Code
+}
+
+type embeddedMetaDocument struct {
+	*taggedDocument
+	Name string `json:"name"`
+}
+
+type objectIDDocument struct {
+	ID primitive.ObjectID `bson:"_id" json:"id"`
+}
+
+func TestDocumentMeta(t *testing.T) {
+	objectID := primitive.NewObjectID()
+	revision := int64(3)
+	tests := []struct {
+		name     string
+		document interface{}
+		want     string
+	}{
+		{
+			name:     "tags",
+			document: taggedDocument{UID: 42, TenantID: "t1", Revision: 7},
+			want:     `{"ID":"42","Routing":"t1","Version":7}`,
+		},
+		{
+			name:     "zero version",
+			document: &taggedDocument{UID: 42},
+			want:     `{"ID":"42","Routing":"","Version":0}`,
+		},
+		{
+			name: "pointer fields",
+			document: struct {
+				ID       *string `es:"id"`
+				Revision *int64  `es:"version"`
+				Routing  *int    `es:"routing"`
+			}{ID: stringPtr("a"), Revision: &revision},
+			want: `{"ID":"a","Routing":"","Version":3}`,
+		},
+		{
+			name:     "ID fallback",
+			document: legacyIDDocument{ID: "1"},
+			want:     `{"ID":"1","Routing":"","Version":null}`,
+		},
+		{
+			name:     "identifiable",
+			document: identifiableDocument{ID: "1", Code: "a"},
+			want:     `{"ID":"code-a","Routing":"","Version":null}`,
+		},
+		{
+			name:     "embedded",
+			document: embeddedMetaDocument{taggedDocument: &taggedDocument{UID: 1, TenantID: "t1"}},
+			want:     `{"ID":"1","Routing":"t1","Version":0}`,
+		},
+		{
+			name:     "object id",
+			document: objectIDDocument{ID: objectID},
+			want:     `{"ID":"` + objectID.Hex() + `","Routing":"","Version":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, err := documentMetaOf(tt.document)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocumentMetaError(t *testing.T) {
+	tests := []struct {
+		name     string
+		document interface{}
+	}{
+		{name: "nil", document: (*taggedDocument)(nil)},
+		{name: "zero numeric id", document: taggedDocument{TenantID: "t1"}},
+		{name: "empty string id", document: legacyIDDocument{Name: "a"}},
+		{name: "zero object id", document: objectIDDocument{}},
+		{name: "nil id pointer", document: struct {
+			ID *int64 `es:"id"`
+		}{}},
+		{name: "nil embedded pointer", document: embeddedMetaDocument{Name: "a"}},
+		{name: "no id", document: struct{ Name string }{Name: "a"}},
+		{name: "not a struct", document: map[string]string{"id": "1"}},
+		{name: "invalid version", document: struct {
+			ID       string `es:"id"`
+			Revision string `es:"version"`
+		}{ID: "1", Revision: "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if meta, err := documentMetaOf(tt.document); err == nil {
+				t.Fatalf("expected error, got %+v", meta)
+			}
+		})
+	}
+}
+
+func TestBulkHeader(t *testing.T) {
+	version := int64(7)
+	meta := documentMeta{ID: "1", Routing: "t1", Version: &version}
+	tests := []struct {
+		action string
+		meta   documentMeta
+		want   string
+	}{
+		{action: "index", meta: meta, want: `{"_id":"1","_index":"products","routing":"t1","version":7,"version_type":"external"}`},
+		{action: "create", meta: meta, want: `{"_id":"1","_index":"products","routing":"t1"}`},
+		{action: "index", meta: documentMeta{ID: "1"}, want: `{"_id":"1","_index":"products"}`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(bulkHeader(tt.action, "products", tt.meta))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got  %s\nwant %s", tt.action, got, tt.want)
+		}
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
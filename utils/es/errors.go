@@ -0,0 +1,142 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/pkg/errors"
+)
+
+// Error es 返回的错误响应
+//
+//	err := es.DeleteESIndex(index, esClient)
+//	if es.IsIndexMissing(err) {
+//		...
+//	}
+type Error struct {
+	StatusCode   int
+	Type         string
+	Reason       string
+	Index        string
+	RootCauses   []ErrorCause
+	FailedShards []ShardFailure
+	// Body 响应体不是 es 的错误格式时（如代理返回的 502 页面）保存原始内容
+	Body string
+}
+
+// ErrorCause 错误原因
+type ErrorCause struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+	Index  string `json:"index,omitempty"`
+}
+
+// ShardFailure 单个分片的错误
+type ShardFailure struct {
+	Shard  int        `json:"shard"`
+	Index  string     `json:"index"`
+	Node   string     `json:"node"`
+	Reason ErrorCause `json:"reason"`
+}
+
+func (e *Error) Error() string {
+	status := fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Type == "" && e.Reason == "" {
+		if e.Body != "" {
+			return fmt.Sprintf("[%s] %s", status, e.Body)
+		}
+		return fmt.Sprintf("[%s]", status)
+	}
+	if e.Type == "" {
+		return fmt.Sprintf("[%s] %s", status, e.Reason)
+	}
+	return fmt.Sprintf("[%s] %s: %s", status, e.Type, e.Reason)
+}
+
+// IsNotFound 文档或索引不存在
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// IsVersionConflict 版本冲突，如 create 已存在的文档、external version 小于当前版本
+func IsVersionConflict(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && (e.StatusCode == http.StatusConflict || e.Type == "version_conflict_engine_exception")
+}
+
+// IsIndexMissing 索引不存在
+func IsIndexMissing(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Type == "index_not_found_exception"
+}
+
+// IsTooManyRequests 集群繁忙拒绝了请求
+func IsTooManyRequests(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && (e.StatusCode == http.StatusTooManyRequests || e.Type == "es_rejected_execution_exception")
+}
+
+// maxErrorBodySize 无法解析的响应体最多保留的字节数
+const maxErrorBodySize = 1024
+
+// responseError 将 es 的错误响应转换为 *Error，响应体中没有 error 对象时（如代理返回的 502）不会 panic
+func responseError(res *esapi.Response) error {
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.WithStack(&Error{StatusCode: res.StatusCode})
+	}
+	return errorFromBody(res.StatusCode, body)
+}
+
+// errorFromBody 将错误响应体转换为 *Error，也用于 _msearch 等接口中单个请求的错误
+func errorFromBody(statusCode int, body []byte) error {
+	e := &Error{StatusCode: statusCode}
+
+	var r struct {
+		Error json.RawMessage `json:"error"`
+	}
+	// 没有 error 或 error 为 null 时保留原始响应体
+	if err := json.Unmarshal(body, &r); err != nil || len(r.Error) == 0 || string(r.Error) == "null" {
+		e.Body = truncate(string(body), maxErrorBodySize)
+		return errors.WithStack(e)
+	}
+
+	// 部分接口的 error 只是一个字符串
+	var reason string
+	if err := json.Unmarshal(r.Error, &reason); err == nil {
+		e.Reason = reason
+		return errors.WithStack(e)
+	}
+
+	var cause struct {
+		Type         string         `json:"type"`
+		Reason       string         `json:"reason"`
+		Index        string         `json:"index"`
+		RootCause    []ErrorCause   `json:"root_cause"`
+		FailedShards []ShardFailure `json:"failed_shards"`
+	}
+	if err := json.Unmarshal(r.Error, &cause); err != nil {
+		e.Body = truncate(string(body), maxErrorBodySize)
+		return errors.WithStack(e)
+	}
+	e.Type = cause.Type
+	e.Reason = cause.Reason
+	e.Index = cause.Index
+	e.RootCauses = cause.RootCause
+	e.FailedShards = cause.FailedShards
+	if e.Index == "" && len(e.RootCauses) > 0 {
+		e.Index = e.RootCauses[0].Index
+	}
+	return errors.WithStack(e)
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,151 @@
+package es
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestErrorFromBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		want       Error
+		wantString string
+	}{
+		{
+			name:       "error object",
+			statusCode: http.StatusNotFound,
+			body: `{"error":{"root_cause":[{"type":"index_not_found_exception","reason":"no such index [products]","index":"products"}],` +
+				`"type":"index_not_found_exception","reason":"no such index [products]","index":"products"},"status":404}`,
+			want: Error{
+				StatusCode: http.StatusNotFound,
+				Type:       "index_not_found_exception",
+				Reason:     "no such index [products]",
+				Index:      "products",
+				RootCauses: []ErrorCause{{Type: "index_not_found_exception", Reason: "no such index [products]", Index: "products"}},
+			},
+			wantString: "[404 Not Found] index_not_found_exception: no such index [products]",
+		},
+		{
+			name:       "index from root cause",
+			statusCode: http.StatusBadRequest,
+			body: `{"error":{"root_cause":[{"type":"query_shard_exception","reason":"failed to create query","index":"products"}],` +
+				`"type":"search_phase_execution_exception","reason":"all shards failed",` +
+				`"failed_shards":[{"shard":0,"index":"products","node":"n1","reason":{"type":"query_shard_exception","reason":"failed to create query","index":"products"}}]},"status":400}`,
+			want: Error{
+				StatusCode: http.StatusBadRequest,
+				Type:       "search_phase_execution_exception",
+				Reason:     "all shards failed",
+				Index:      "products",
+				RootCauses: []ErrorCause{{Type: "query_shard_exception", Reason: "failed to create query", Index: "products"}},
+				FailedShards: []ShardFailure{{
+					Index:  "products",
+					Node:   "n1",
+					Reason: ErrorCause{Type: "query_shard_exception", Reason: "failed to create query", Index: "products"},
+				}},
+			},
+			wantString: "[400 Bad Request] search_phase_execution_exception: all shards failed",
+		},
+		{
+			name:       "string error",
+			statusCode: http.StatusBadRequest,
+			body:       `{"error":"Incorrect HTTP method for uri [/_bulk] and method [GET]","status":405}`,
+			want:       Error{StatusCode: http.StatusBadRequest, Reason: "Incorrect HTTP method for uri [/_bulk] and method [GET]"},
+			wantString: "[400 Bad Request] Incorrect HTTP method for uri [/_bulk] and method [GET]",
+		},
+		{
+			name:       "missing error key",
+			statusCode: http.StatusNotFound,
+			body:       `{"_index":"products","_id":"1","found":false}`,
+			want:       Error{StatusCode: http.StatusNotFound, Body: `{"_index":"products","_id":"1","found":false}`},
+			wantString: `[404 Not Found] {"_index":"products","_id":"1","found":false}`,
+		},
+		{
+			name:       "null error",
+			statusCode: http.StatusInternalServerError,
+			body:       `{"error":null}`,
+			want:       Error{StatusCode: http.StatusInternalServerError, Body: `{"error":null}`},
+			wantString: `[500 Internal Server Error] {"error":null}`,
+		},
+		{
+			name:       "malformed error",
+			statusCode: http.StatusInternalServerError,
+			body:       `{"error":123}`,
+			want:       Error{StatusCode: http.StatusInternalServerError, Body: `{"error":123}`},
+			wantString: `[500 Internal Server Error] {"error":123}`,
+		},
+		{
+			name:       "html",
+			statusCode: http.StatusBadGateway,
+			body:       "<html><body>502 Bad Gateway</body></html>",
+			want:       Error{StatusCode: http.StatusBadGateway, Body: "<html><body>502 Bad Gateway</body></html>"},
+			wantString: "[502 Bad Gateway] <html><body>502 Bad Gateway</body></html>",
+		},
+		{
+			name:       "empty",
+			statusCode: http.StatusServiceUnavailable,
+			want:       Error{StatusCode: http.StatusServiceUnavailable},
+			wantString: "[503 Service Unavailable]",
+		},
+		{
+			name:       "truncated",
+			statusCode: http.StatusBadGateway,
+			body:       strings.Repeat("a", maxErrorBodySize+10),
+			want:       Error{StatusCode: http.StatusBadGateway, Body: strings.Repeat("a", maxErrorBodySize) + "..."},
+			wantString: "[502 Bad Gateway] " + strings.Repeat("a", maxErrorBodySize) + "...",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := errorFromBody(tt.statusCode, []byte(tt.body))
+			var e *Error
+			if !errors.As(err, &e) {
+				t.Fatalf("expected *Error, got %T", err)
+			}
+			if !reflect.DeepEqual(*e, tt.want) {
+				t.Fatalf("expected %+v, got %+v", tt.want, *e)
+			}
+			if e.Error() != tt.wantString {
+				t.Fatalf("expected %q, got %q", tt.wantString, e.Error())
+			}
+		})
+	}
+}
+
+func TestErrorHelpers(t *testing.T) {
+	notFound := errorFromBody(http.StatusNotFound, []byte(`{"_id":"1","found":false}`))
+	indexMissing := errorFromBody(http.StatusNotFound, []byte(`{"error":{"type":"index_not_found_exception","reason":"no such index"},"status":404}`))
+	conflict := errorFromBody(http.StatusConflict, []byte(`{"error":{"type":"version_conflict_engine_exception","reason":"document already exists"},"status":409}`))
+	rejected := errorFromBody(http.StatusInternalServerError, []byte(`{"error":{"type":"es_rejected_execution_exception","reason":"rejected"}}`))
+	tooMany := errorFromBody(http.StatusTooManyRequests, []byte(`<html>429</html>`))
+
+	tests := []struct {
+		name string
+		err  error
+		// want 依次为 IsNotFound、IsVersionConflict、IsIndexMissing、IsTooManyRequests
+		want [4]bool
+	}{
+		{name: "nil", err: nil},
+		{name: "other error", err: errors.New("connection refused")},
+		{name: "not found", err: notFound, want: [4]bool{true, false, false, false}},
+		{name: "index missing", err: indexMissing, want: [4]bool{true, false, true, false}},
+		{name: "wrapped index missing", err: errors.Wrap(errors.WithMessage(indexMissing, "search"), "products"), want: [4]bool{true, false, true, false}},
+		{name: "conflict", err: errors.Wrapf(conflict, "insert %s", "1"), want: [4]bool{false, true, false, false}},
+		{name: "rejected", err: errors.Wrap(rejected, "bulk"), want: [4]bool{false, false, false, true}},
+		{name: "too many requests", err: errors.WithStack(tooMany), want: [4]bool{false, false, false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := [4]bool{IsNotFound(tt.err), IsVersionConflict(tt.err), IsIndexMissing(tt.err), IsTooManyRequests(tt.err)}
+			if got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
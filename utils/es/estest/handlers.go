@@ -0,0 +1,478 @@
+package estest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func (s *Server) handleCreateIndex(r *http.Request, name string) (int, interface{}) {
+	if _, ok := s.indices[name]; ok {
+		return errorResponse(&apiError{status: http.StatusBadRequest, kind: "resource_already_exists_exception",
+			reason: "index [" + name + "] already exists", index: name})
+	}
+	if _, ok := s.aliases[name]; ok {
+		return errorResponse(&apiError{status: http.StatusBadRequest, kind: "invalid_index_name_exception",
+			reason: "Invalid index name [" + name + "], an alias with the same name already exists", index: name})
+	}
+	var body struct {
+		Settings json.RawMessage            `json:"settings"`
+		Mappings json.RawMessage            `json:"mappings"`
+		Aliases  map[string]json.RawMessage `json:"aliases"`
+	}
+	if err := decodeBody(r, &body); err != nil {
+		return errorResponse(err)
+	}
+
+	for alias := range body.Aliases {
+		if _, ok := s.indices[alias]; ok || alias == name {
+			return errorResponse(invalidAliasName(alias))
+		}
+	}
+
+	idx := newIndex(name)
+	idx.settings = body.Settings
+	idx.mappings = body.Mappings
+	s.indices[name] = idx
+	for alias := range body.Aliases {
+		s.addAlias(alias, name)
+	}
+	return http.StatusOK, map[string]interface{}{
+		"acknowledged":        true,
+		"shards_acknowledged": true,
+		"index":               name,
+	}
+}
+
+func (s *Server) handleDeleteIndex(expr string) (int, interface{}) {
+	var names []string
+	for _, name := range strings.Split(expr, ",") {
+		if strings.HasSuffix(name, "*") {
+			for _, idx := range s.sortedIndices(func(n string) bool { return strings.HasPrefix(n, strings.TrimSuffix(name, "*")) }) {
+				names = append(names, idx.name)
+			}
+			continue
+		}
+		// 删除索引时不能使用别名
+		if _, ok := s.indices[name]; !ok {
+			return errorResponse(indexNotFound(name))
+		}
+		names = append(names, name)
+	}
+	for _, name := range names {
+		delete(s.indices, name)
+		for alias, members := range s.aliases {
+			delete(members, name)
+			if len(members) == 0 {
+				delete(s.aliases, alias)
+			}
+		}
+	}
+	return http.StatusOK, acknowledged()
+}
+
+// handleUpdateIndex _mapping、_settings 只保存请求体，不影响查询
+func (s *Server) handleUpdateIndex(r *http.Request, expr string, api string) (int, interface{}) {
+	indices, err := s.resolve(expr)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if r.Method == http.MethodGet {
+		result := make(map[string]interface{}, len(indices))
+		for _, idx := range indices {
+			if api == "_mapping" {
+				result[idx.name] = map[string]interface{}{"mappings": rawOrEmpty(idx.mappings)}
+			} else {
+				result[idx.name] = map[string]interface{}{"settings": rawOrEmpty(idx.settings)}
+			}
+		}
+		return http.StatusOK, result
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err)
+	}
+	for _, idx := range indices {
+		if api == "_mapping" {
+			idx.mappings = body
+		} else {
+			idx.settings = body
+		}
+	}
+	return http.StatusOK, acknowledged()
+}
+
+type aliasAction struct {
+	Index   string   `json:"index"`
+	Indices []string `json:"indices"`
+	Alias   string   `json:"alias"`
+	Aliases []string `json:"aliases"`
+}
+
+func (a aliasAction) indexNames() []string {
+	if a.Index != "" {
+		return append([]string{a.Index}, a.Indices...)
+	}
+	return a.Indices
+}
+
+func (a aliasAction) aliasNames() []string {
+	if a.Alias != "" {
+		return append([]string{a.Alias}, a.Aliases...)
+	}
+	return a.Aliases
+}
+
+// handleUpdateAliases 先校验所有操作，全部合法时才执行，保证原子性
+func (s *Server) handleUpdateAliases(r *http.Request) (int, interface{}) {
+	var body struct {
+		Actions []map[string]aliasAction `json:"actions"`
+	}
+	if err := decodeBody(r, &body); err != nil {
+		return errorResponse(err)
+	}
+
+	// 与 es 一致，别名不能与索引同名，除非该索引在同一个请求中被 remove_index 删除
+	removedIndices := make(map[string]bool)
+	for _, action := range body.Actions {
+		if a, ok := action["remove_index"]; ok {
+			for _, name := range a.indexNames() {
+				removedIndices[name] = true
+			}
+		}
+	}
+
+	for _, action := range body.Actions {
+		for kind, a := range action {
+			if kind == "add" {
+				for _, alias := range a.aliasNames() {
+					if _, ok := s.indices[alias]; ok && !removedIndices[alias] {
+						return errorResponse(invalidAliasName(alias))
+					}
+				}
+			}
+			for _, name := range a.indexNames() {
+				if _, ok := s.indices[name]; !ok {
+					return errorResponse(indexNotFound(name))
+				}
+				if kind != "remove" {
+					continue
+				}
+				for _, alias := range a.aliasNames() {
+					if !s.aliases[alias][name] {
+						return errorResponse(&apiError{status: http.StatusNotFound, kind: "aliases_not_found_exception",
+							reason: "aliases [" + alias + "] missing"})
+					}
+				}
+			}
+			if kind != "add" && kind != "remove" && kind != "remove_index" {
+				return errorResponse(badRequest("unknown alias action [" + kind + "]"))
+			}
+		}
+	}
+
+	for _, action := range body.Actions {
+		for kind, a := range action {
+			for _, name := range a.indexNames() {
+				switch kind {
+				case "add":
+					for _, alias := range a.aliasNames() {
+						s.addAlias(alias, name)
+					}
+				case "remove":
+					for _, alias := range a.aliasNames() {
+						s.removeAlias(alias, name)
+					}
+				case "remove_index":
+					s.handleDeleteIndex(name)
+				}
+			}
+		}
+	}
+	return http.StatusOK, acknowledged()
+}
+
+func invalidAliasName(alias string) *apiError {
+	return &apiError{status: http.StatusBadRequest, kind: "invalid_alias_name_exception",
+		reason: "Invalid alias name [" + alias + "]: an index or data stream exists with the same name as the alias"}
+}
+
+func (s *Server) handleGetAlias(indexExpr string, aliasExpr string) (int, interface{}) {
+	indices, err := s.resolve(indexExpr)
+	if err != nil {
+		return errorResponse(err)
+	}
+	wanted := make(map[string]bool)
+	for _, alias := range strings.Split(aliasExpr, ",") {
+		if alias != "" {
+			wanted[alias] = true
+		}
+	}
+
+	result := make(map[string]interface{})
+	for _, idx := range indices {
+		aliases := make(map[string]interface{})
+		for alias, members := range s.aliases {
+			if members[idx.name] && (len(wanted) == 0 || wanted[alias]) {
+				aliases[alias] = map[string]interface{}{}
+			}
+		}
+		if len(aliases) > 0 || len(wanted) == 0 {
+			result[idx.name] = map[string]interface{}{"aliases": aliases}
+		}
+	}
+	if len(result) == 0 {
+		return http.StatusNotFound, map[string]interface{}{
+			"error":  "alias [" + aliasExpr + "] missing",
+			"status": http.StatusNotFound,
+		}
+	}
+	return http.StatusOK, result
+}
+
+func (s *Server) addAlias(alias string, name string) {
+	if s.aliases[alias] == nil {
+		s.aliases[alias] = make(map[string]bool)
+	}
+	s.aliases[alias][name] = true
+}
+
+func (s *Server) removeAlias(alias string, name string) {
+	delete(s.aliases[alias], name)
+	if len(s.aliases[alias]) == 0 {
+		delete(s.aliases, alias)
+	}
+}
+
+func (s *Server) handleGetDocument(name string, id string) (int, interface{}) {
+	indices, err := s.resolve(name)
+	if err != nil {
+		return errorResponse(err)
+	}
+	for _, idx := range indices {
+		if doc := idx.docs[id]; doc != nil {
+			return http.StatusOK, map[string]interface{}{
+				"_index":   idx.name,
+				"_id":      id,
+				"_version": doc.version,
+				"found":    true,
+				"_source":  doc.source,
+			}
+		}
+	}
+	return http.StatusNotFound, map[string]interface{}{"_index": name, "_id": id, "found": false}
+}
+
+type bulkMeta struct {
+	Index       string `json:"_index"`
+	ID          string `json:"_id"`
+	Version     *int64 `json:"version"`
+	VersionType string `json:"version_type"`
+}
+
+func (s *Server) handleBulk(r *http.Request, defaultIndex string) (int, interface{}) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err)
+	}
+	lines := splitLines(body)
+
+	items := make([]interface{}, 0, len(lines)/2)
+	hasErrors := false
+	for i := 0; i < len(lines); i++ {
+		var header map[string]bulkMeta
+		if err := json.Unmarshal(lines[i], &header); err != nil || len(header) != 1 {
+			return errorResponse(badRequest("Malformed action/metadata line [" + strconv.Itoa(i+1) + "]"))
+		}
+		for action, meta := range header {
+			var source []byte
+			if action != "delete" {
+				if i+1 >= len(lines) {
+					return errorResponse(badRequest("The bulk request must be terminated by a newline [\\n]"))
+				}
+				i++
+				source = lines[i]
+			}
+			if meta.Index == "" {
+				meta.Index = defaultIndex
+			}
+			item := s.bulkItem(action, meta, source)
+			if _, failed := item["error"]; failed {
+				hasErrors = true
+			}
+			items = append(items, map[string]interface{}{action: item})
+		}
+	}
+	return http.StatusOK, map[string]interface{}{"took": 1, "errors": hasErrors, "items": items}
+}
+
+func (s *Server) bulkItem(action string, meta bulkMeta, source []byte) map[string]interface{} {
+	item := map[string]interface{}{"_index": meta.Index, "_id": meta.ID}
+	fail := func(err error) map[string]interface{} {
+		e, ok := err.(*apiError)
+		if !ok {
+			e = &apiError{status: http.StatusInternalServerError, kind: "exception", reason: err.Error()}
+		}
+		item["status"] = e.status
+		item["error"] = map[string]interface{}{"type": e.kind, "reason": e.reason, "index": meta.Index}
+		return item
+	}
+	succeed := func(doc *document, result string, status int) map[string]interface{} {
+		item["_version"] = doc.version
+		item["result"] = result
+		item["status"] = status
+		item["_shards"] = map[string]interface{}{"total": 1, "successful": 1, "failed": 0}
+		return item
+	}
+
+	if meta.Index == "" {
+		return fail(&apiError{status: http.StatusBadRequest, kind: "action_request_validation_exception",
+			reason: "Validation Failed: 1: index is missing;"})
+	}
+	idx, err := s.resolveWrite(meta.Index)
+	if err != nil {
+		return fail(err)
+	}
+	item["_index"] = idx.name
+	if meta.ID == "" && (action == "index" || action == "create") {
+		meta.ID = s.nextID()
+		item["_id"] = meta.ID
+	}
+	existing := idx.docs[meta.ID]
+
+	switch action {
+	case "index", "create":
+		if action == "create" && existing != nil {
+			return fail(versionConflict(meta.ID, existing.version, "document already exists"))
+		}
+		external := meta.Version != nil && strings.HasPrefix(meta.VersionType, "external")
+		if external && existing != nil && existing.version >= *meta.Version {
+			return fail(versionConflict(meta.ID, existing.version,
+				"version ["+strconv.FormatInt(*meta.Version, 10)+"] is lower or equal to the current version"))
+		}
+		doc, err := idx.put(meta.ID, source)
+		if err != nil {
+			return fail(err)
+		}
+		if external {
+			doc.version = *meta.Version
+		}
+		if existing != nil {
+			return succeed(doc, "updated", http.StatusOK)
+		}
+		return succeed(doc, "created", http.StatusCreated)
+
+	case "update":
+		var update struct {
+			Doc         map[string]interface{} `json:"doc"`
+			DocAsUpsert bool                   `json:"doc_as_upsert"`
+			Upsert      map[string]interface{} `json:"upsert"`
+			Script      json.RawMessage        `json:"script"`
+		}
+		if err := json.Unmarshal(source, &update); err != nil {
+			return fail(badRequest("failed to parse update request: " + err.Error()))
+		}
+		if len(update.Script) > 0 {
+			return fail(badRequest("script updates are not supported by estest"))
+		}
+		var fields map[string]interface{}
+		switch {
+		case existing != nil:
+			fields = mergeFields(existing.fields, update.Doc)
+		case update.Upsert != nil:
+			fields = update.Upsert
+		case update.DocAsUpsert:
+			fields = update.Doc
+		default:
+			return fail(&apiError{status: http.StatusNotFound, kind: "document_missing_exception",
+				reason: "[_doc][" + meta.ID + "]: document missing"})
+		}
+		raw, err := json.Marshal(fields)
+		if err != nil {
+			return fail(err)
+		}
+		if existing != nil && bytes.Equal(raw, existing.source) {
+			return succeed(existing, "noop", http.StatusOK)
+		}
+		doc, err := idx.put(meta.ID, raw)
+		if err != nil {
+			return fail(err)
+		}
+		if existing != nil {
+			return succeed(doc, "updated", http.StatusOK)
+		}
+		return succeed(doc, "created", http.StatusCreated)
+
+	case "delete":
+		doc := idx.remove(meta.ID)
+		if doc == nil {
+			return succeed(&document{version: 1}, "not_found", http.StatusNotFound)
+		}
+		doc.version++
+		return succeed(doc, "deleted", http.StatusOK)
+	}
+	return fail(badRequest("Malformed action/metadata line, expected one of [create, delete, index, update] but found [" + action + "]"))
+}
+
+func versionConflict(id string, current int64, reason string) *apiError {
+	return &apiError{status: http.StatusConflict, kind: "version_conflict_engine_exception",
+		reason: "[" + id + "]: version conflict, " + reason + " (current version [" + strconv.FormatInt(current, 10) + "])"}
+}
+
+// mergeFields 与 es 的部分更新一致，对象字段递归合并，其他字段直接覆盖
+func mergeFields(dst map[string]interface{}, src map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(dst)+len(src))
+	for k, v := range dst {
+		merged[k] = v
+	}
+	for k, v := range src {
+		srcObj, ok1 := v.(map[string]interface{})
+		dstObj, ok2 := merged[k].(map[string]interface{})
+		if ok1 && ok2 {
+			merged[k] = mergeFields(dstObj, srcObj)
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+// decodeBody 解析 JSON 请求体，请求体为空时不做处理
+func decodeBody(r *http.Request, v interface{}) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return badRequest("failed to parse request body: " + err.Error())
+	}
+	return nil
+}
+
+// splitLines 拆分 NDJSON，忽略空行
+func splitLines(body []byte) [][]byte {
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) > 0 {
+			lines = append(lines, append([]byte{}, line...))
+		}
+	}
+	return lines
+}
+
+func rawOrEmpty(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return map[string]interface{}{}
+	}
+	return raw
+}
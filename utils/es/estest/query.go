@@ -0,0 +1,344 @@
+package estest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// matches 判断文档是否满足查询条件
+func matches(query map[string]interface{}, doc *document) (bool, error) {
+	if len(query) != 1 {
+		return false, badRequest("query malformed, must contain exactly one query type")
+	}
+	for kind, body := range query {
+		switch kind {
+		case "match_all":
+			return true, nil
+		case "match_none":
+			return false, nil
+		case "bool":
+			clauses, ok := body.(map[string]interface{})
+			if !ok {
+				return false, badRequest("[bool] query malformed")
+			}
+			return matchBool(clauses, doc)
+		case "ids":
+			params, _ := body.(map[string]interface{})
+			for _, id := range toStrings(params["values"]) {
+				if id == doc.id {
+					return true, nil
+				}
+			}
+			return false, nil
+		case "exists":
+			params, _ := body.(map[string]interface{})
+			field, _ := params["field"].(string)
+			return len(fieldValues(doc.fields, field)) > 0, nil
+		case "term", "terms", "match", "match_phrase", "range", "prefix", "wildcard":
+			field, params, err := fieldQuery(kind, body)
+			if err != nil {
+				return false, err
+			}
+			if field == "_id" {
+				return matchValues(kind, params, []interface{}{doc.id})
+			}
+			return matchValues(kind, params, fieldValues(doc.fields, field))
+		}
+		return false, badRequest("unknown query [" + kind + "]")
+	}
+	return false, nil
+}
+
+func matchBool(clauses map[string]interface{}, doc *document) (bool, error) {
+	for _, kind := range []string{"must", "filter"} {
+		for _, clause := range boolClauses(clauses[kind]) {
+			ok, err := matches(clause, doc)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+	for _, clause := range boolClauses(clauses["must_not"]) {
+		ok, err := matches(clause, doc)
+		if err != nil || ok {
+			return false, err
+		}
+	}
+
+	should := boolClauses(clauses["should"])
+	if len(should) == 0 {
+		return true, nil
+	}
+	// 与 es 一致，没有 must、filter 时 should 至少满足一个
+	minimum := 0
+	if clauses["must"] == nil && clauses["filter"] == nil {
+		minimum = 1
+	}
+	switch v := clauses["minimum_should_match"].(type) {
+	case float64:
+		minimum = int(v)
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			minimum = n
+		}
+	}
+	matched := 0
+	for _, clause := range should {
+		ok, err := matches(clause, doc)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			matched++
+		}
+	}
+	return matched >= minimum, nil
+}
+
+// boolClauses bool 查询中的子句可以是单个对象，也可以是数组
+func boolClauses(v interface{}) []map[string]interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{v}
+	case []interface{}:
+		clauses := make([]map[string]interface{}, 0, len(v))
+		for _, item := range v {
+			if clause, ok := item.(map[string]interface{}); ok {
+				clauses = append(clauses, clause)
+			}
+		}
+		return clauses
+	}
+	return nil
+}
+
+// fieldQuery 解析 {"field": value} 或 {"field": {"value": value, ...}} 形式的查询，返回字段名以及参数
+// 简写形式的 value 会被放到参数的 value（term、prefix、wildcard）、query（match）或 values（terms）中
+func fieldQuery(kind string, body interface{}) (string, map[string]interface{}, error) {
+	params, ok := body.(map[string]interface{})
+	if !ok {
+		return "", nil, badRequest("[" + kind + "] query malformed")
+	}
+	for field, value := range params {
+		if field == "boost" || field == "_name" {
+			continue
+		}
+		if kind == "terms" {
+			return field, map[string]interface{}{"values": value}, nil
+		}
+		if p, ok := value.(map[string]interface{}); ok {
+			return field, p, nil
+		}
+		key := "value"
+		if kind == "match" || kind == "match_phrase" {
+			key = "query"
+		}
+		return field, map[string]interface{}{key: value}, nil
+	}
+	return "", nil, badRequest("[" + kind + "] query does not have a field")
+}
+
+func matchValues(kind string, params map[string]interface{}, values []interface{}) (bool, error) {
+	switch kind {
+	case "term":
+		return containsValue(values, params["value"]), nil
+	case "terms":
+		candidates, ok := params["values"].([]interface{})
+		if !ok {
+			return false, badRequest("[terms] query requires an array of values")
+		}
+		for _, candidate := range candidates {
+			if containsValue(values, candidate) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "match":
+		query := tokenize(fmt.Sprint(params["query"]))
+		and := strings.EqualFold(fmt.Sprint(params["operator"]), "and")
+		tokens := make(map[string]bool)
+		for _, value := range values {
+			for _, token := range tokenize(fmt.Sprint(value)) {
+				tokens[token] = true
+			}
+		}
+		matched := 0
+		for _, token := range query {
+			if tokens[token] {
+				matched++
+			}
+		}
+		if and {
+			return len(query) > 0 && matched == len(query), nil
+		}
+		return matched > 0, nil
+	case "match_phrase":
+		phrase := strings.Join(tokenize(fmt.Sprint(params["query"])), " ")
+		for _, value := range values {
+			text := " " + strings.Join(tokenize(fmt.Sprint(value)), " ") + " "
+			if strings.Contains(text, " "+phrase+" ") {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "range":
+		for _, value := range values {
+			if inRange(value, params) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "prefix":
+		prefix := fmt.Sprint(params["value"])
+		for _, value := range values {
+			if s, ok := value.(string); ok && strings.HasPrefix(s, prefix) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "wildcard":
+		pattern := regexp.QuoteMeta(fmt.Sprint(params["value"]))
+		pattern = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(pattern)
+		re, err := regexp.Compile("^" + pattern + "$")
+		if err != nil {
+			return false, badRequest("[wildcard] invalid pattern")
+		}
+		for _, value := range values {
+			if s, ok := value.(string); ok && re.MatchString(s) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, badRequest("unknown query [" + kind + "]")
+}
+
+func inRange(value interface{}, params map[string]interface{}) bool {
+	for op, bound := range params {
+		c, ok := compareValues(value, bound)
+		if !ok {
+			switch op {
+			case "gt", "gte", "lt", "lte":
+				return false
+			}
+			continue
+		}
+		switch {
+		case op == "gt" && c <= 0,
+			op == "gte" && c < 0,
+			op == "lt" && c >= 0,
+			op == "lte" && c > 0:
+			return false
+		}
+	}
+	return true
+}
+
+func containsValue(values []interface{}, target interface{}) bool {
+	for _, value := range values {
+		if c, ok := compareValues(value, target); ok && c == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// compareValues 数字按数值比较，其他类型按字符串比较；ok 为 false 表示无法比较
+// 字符串形式的数字与数字比较时转换为数字，与 es 对数值字段的处理一致
+func compareValues(a interface{}, b interface{}) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	af, aNum := toFloat(a)
+	bf, bNum := toFloat(b)
+	if aNum && bNum {
+		switch {
+		case af < bf:
+			return -1, true
+		case af > bf:
+			return 1, true
+		}
+		return 0, true
+	}
+	as, bs := fmt.Sprint(a), fmt.Sprint(b)
+	return strings.Compare(as, bs), true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// fieldValues 按 a.b.c 形式的路径读取字段的所有值，数组会被展开
+// 字段不存在时去掉 .keyword 等子字段后缀再读取一次
+func fieldValues(fields map[string]interface{}, path string) []interface{} {
+	values := lookup(fields, strings.Split(path, "."))
+	if len(values) == 0 {
+		if i := strings.LastIndex(path, "."); i > 0 {
+			values = lookup(fields, strings.Split(path[:i], "."))
+		}
+	}
+	return values
+}
+
+func lookup(value interface{}, path []string) []interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		var values []interface{}
+		for _, item := range v {
+			values = append(values, lookup(item, path)...)
+		}
+		return values
+	case map[string]interface{}:
+		if len(path) == 0 {
+			return []interface{}{v}
+		}
+		// 字段名本身可能包含点
+		for i := len(path); i > 0; i-- {
+			if child, ok := v[strings.Join(path[:i], ".")]; ok {
+				return lookup(child, path[i:])
+			}
+		}
+		return nil
+	}
+	if len(path) > 0 {
+		return nil
+	}
+	return []interface{}{value}
+}
+
+// tokenize 与 standard analyzer 类似，按非字母数字字符切分并转换为小写，中文按单字切分
+func tokenize(text string) []string {
+	var tokens []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			current.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
@@ -0,0 +1,427 @@
+package estest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type searchRequest struct {
+	Query  map[string]interface{} `json:"query"`
+	From   int                    `json:"from"`
+	Size   *int                   `json:"size"`
+	Sort   interface{}            `json:"sort"`
+	Source interface{}            `json:"_source"`
+}
+
+type hit struct {
+	index  string
+	doc    *document
+	sort   []interface{}
+	source json.RawMessage
+}
+
+type sortField struct {
+	field string
+	desc  bool
+}
+
+const defaultSize = 10
+
+func (s *Server) handleSearch(r *http.Request, expr string) (int, interface{}) {
+	var req searchRequest
+	if err := decodeBody(r, &req); err != nil {
+		return errorResponse(err)
+	}
+	if size := r.URL.Query().Get("size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return errorResponse(badRequest("invalid size [" + size + "]"))
+		}
+		req.Size = &n
+	}
+
+	hits, err := s.search(expr, req)
+	if err != nil {
+		return errorResponse(err)
+	}
+	size := defaultSize
+	if req.Size != nil {
+		size = *req.Size
+	}
+	total := len(hits)
+
+	if r.URL.Query().Get("scroll") != "" {
+		id := "scroll-" + s.nextID()
+		page, rest := pageOf(hits, 0, size)
+		s.scrolls[id] = &scroll{hits: rest, size: size, total: total}
+		res := searchResponse(page, total, req.Sort != nil)
+		res["_scroll_id"] = id
+		return http.StatusOK, res
+	}
+	page, _ := pageOf(hits, req.From, size)
+	return http.StatusOK, searchResponse(page, total, req.Sort != nil)
+}
+
+func (s *Server) handleScroll(r *http.Request) (int, interface{}) {
+	var body struct {
+		ScrollID string `json:"scroll_id"`
+	}
+	if err := decodeBody(r, &body); err != nil {
+		return errorResponse(err)
+	}
+	id := body.ScrollID
+	if id == "" {
+		id = r.URL.Query().Get("scroll_id")
+	}
+	sc := s.scrolls[id]
+	if sc == nil {
+		return errorResponse(&apiError{status: http.StatusNotFound, kind: "search_context_missing_exception",
+			reason: "No search context found for id [" + id + "]"})
+	}
+	page, rest := pageOf(sc.hits, 0, sc.size)
+	sc.hits = rest
+	res := searchResponse(page, sc.total, false)
+	res["_scroll_id"] = id
+	return http.StatusOK, res
+}
+
+func (s *Server) handleClearScroll(r *http.Request, pathIDs string) (int, interface{}) {
+	var body struct {
+		ScrollID interface{} `json:"scroll_id"`
+	}
+	if err := decodeBody(r, &body); err != nil {
+		return errorResponse(err)
+	}
+	var ids []string
+	switch v := body.ScrollID.(type) {
+	case string:
+		ids = append(ids, v)
+	case []interface{}:
+		for _, id := range v {
+			if id, ok := id.(string); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	for _, id := range []string{pathIDs, r.URL.Query().Get("scroll_id")} {
+		if id != "" {
+			ids = append(ids, strings.Split(id, ",")...)
+		}
+	}
+
+	freed := 0
+	for _, id := range ids {
+		if id == "_all" {
+			freed += len(s.scrolls)
+			s.scrolls = make(map[string]*scroll)
+			continue
+		}
+		if _, ok := s.scrolls[id]; ok {
+			delete(s.scrolls, id)
+			freed++
+		}
+	}
+	status := http.StatusOK
+	if freed == 0 {
+		status = http.StatusNotFound
+	}
+	return status, map[string]interface{}{"succeeded": true, "num_freed": freed}
+}
+
+func (s *Server) handleCount(r *http.Request, expr string) (int, interface{}) {
+	var req searchRequest
+	if err := decodeBody(r, &req); err != nil {
+		return errorResponse(err)
+	}
+	hits, err := s.search(expr, searchRequest{Query: req.Query})
+	if err != nil {
+		return errorResponse(err)
+	}
+	res := shardsOK()
+	res["count"] = len(hits)
+	return http.StatusOK, res
+}
+
+func (s *Server) handleMultiSearch(r *http.Request, defaultIndex string) (int, interface{}) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err)
+	}
+	lines := splitLines(body)
+	if len(lines)%2 != 0 {
+		return errorResponse(badRequest("The msearch request must be terminated by a newline [\\n]"))
+	}
+
+	responses := make([]interface{}, 0, len(lines)/2)
+	for i := 0; i < len(lines); i += 2 {
+		var header struct {
+			Index interface{} `json:"index"`
+		}
+		var req searchRequest
+		if err := json.Unmarshal(lines[i], &header); err != nil {
+			return errorResponse(badRequest("malformed msearch header: " + err.Error()))
+		}
+		if err := json.Unmarshal(lines[i+1], &req); err != nil {
+			return errorResponse(badRequest("malformed msearch body: " + err.Error()))
+		}
+		expr := defaultIndex
+		switch v := header.Index.(type) {
+		case string:
+			expr = v
+		case []interface{}:
+			names := make([]string, 0, len(v))
+			for _, name := range v {
+				if name, ok := name.(string); ok {
+					names = append(names, name)
+				}
+			}
+			expr = strings.Join(names, ",")
+		}
+
+		hits, err := s.search(expr, req)
+		if err != nil {
+			_, res := errorResponse(err)
+			responses = append(responses, res)
+			continue
+		}
+		size := defaultSize
+		if req.Size != nil {
+			size = *req.Size
+		}
+		page, _ := pageOf(hits, req.From, size)
+		res := searchResponse(page, len(hits), req.Sort != nil)
+		res["status"] = http.StatusOK
+		responses = append(responses, res)
+	}
+	return http.StatusOK, map[string]interface{}{"took": 1, "responses": responses}
+}
+
+// search 返回所有满足条件的文档，已按 sort 排序
+func (s *Server) search(expr string, req searchRequest) ([]hit, error) {
+	indices, err := s.resolve(expr)
+	if err != nil {
+		return nil, err
+	}
+	sortFields, err := parseSort(req.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	var hits []hit
+	for _, idx := range indices {
+		for _, id := range idx.order {
+			doc := idx.docs[id]
+			if req.Query != nil {
+				ok, err := matches(req.Query, doc)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					continue
+				}
+			}
+			h := hit{index: idx.name, doc: doc}
+			for _, f := range sortFields {
+				h.sort = append(h.sort, sortValue(doc, f.field))
+			}
+			if h.source, err = filterSource(doc, req.Source); err != nil {
+				return nil, err
+			}
+			hits = append(hits, h)
+		}
+	}
+
+	if len(sortFields) > 0 {
+		sort.SliceStable(hits, func(i, j int) bool {
+			for k, f := range sortFields {
+				c := compareSortValues(hits[i].sort[k], hits[j].sort[k])
+				if c == 0 {
+					continue
+				}
+				if f.desc {
+					return c > 0
+				}
+				return c < 0
+			}
+			return false
+		})
+	}
+	return hits, nil
+}
+
+func pageOf(hits []hit, from int, size int) (page []hit, rest []hit) {
+	if from > len(hits) {
+		from = len(hits)
+	}
+	end := from + size
+	if size < 0 || end > len(hits) {
+		end = len(hits)
+	}
+	return hits[from:end], hits[end:]
+}
+
+func searchResponse(page []hit, total int, sorted bool) map[string]interface{} {
+	items := make([]interface{}, 0, len(page))
+	for _, h := range page {
+		item := map[string]interface{}{
+			"_index": h.index,
+			"_type":  "_doc",
+			"_id":    h.doc.id,
+			"_score": 1.0,
+		}
+		if sorted {
+			item["_score"] = nil
+			item["sort"] = h.sort
+		}
+		if h.source != nil {
+			item["_source"] = h.source
+		}
+		items = append(items, item)
+	}
+	var maxScore interface{}
+	if len(page) > 0 && !sorted {
+		maxScore = 1.0
+	}
+	res := shardsOK()
+	res["took"] = 1
+	res["timed_out"] = false
+	res["hits"] = map[string]interface{}{
+		"total":     map[string]interface{}{"value": total, "relation": "eq"},
+		"max_score": maxScore,
+		"hits":      items,
+	}
+	return res
+}
+
+// parseSort 支持 "field"、{"field": "desc"}、{"field": {"order": "desc"}} 以及它们组成的数组
+func parseSort(spec interface{}) ([]sortField, error) {
+	var specs []interface{}
+	switch v := spec.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		specs = v
+	default:
+		specs = []interface{}{v}
+	}
+
+	fields := make([]sortField, 0, len(specs))
+	for _, spec := range specs {
+		switch v := spec.(type) {
+		case string:
+			fields = append(fields, sortField{field: v, desc: v == "_score"})
+		case map[string]interface{}:
+			for field, order := range v {
+				f := sortField{field: field}
+				switch o := order.(type) {
+				case string:
+					f.desc = o == "desc"
+				case map[string]interface{}:
+					f.desc = o["order"] == "desc"
+				}
+				fields = append(fields, f)
+			}
+		default:
+			return nil, badRequest("malformed sort")
+		}
+	}
+	return fields, nil
+}
+
+func sortValue(doc *document, field string) interface{} {
+	switch field {
+	case "_id":
+		return doc.id
+	case "_score":
+		return 1.0
+	case "_doc", "_shard_doc":
+		// 按写入顺序，用版本号无法区分，直接返回 0，SliceStable 保持原有顺序
+		return 0.0
+	}
+	values := fieldValues(doc.fields, field)
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// compareSortValues 缺失的值排在最后
+func compareSortValues(a interface{}, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	c, _ := compareValues(a, b)
+	return c
+}
+
+// filterSource 处理 _source：false 不返回，字段数组或 {"includes", "excludes"} 只返回部分字段
+func filterSource(doc *document, spec interface{}) (json.RawMessage, error) {
+	var includes, excludes []string
+	switch v := spec.(type) {
+	case nil:
+		return doc.source, nil
+	case bool:
+		if v {
+			return doc.source, nil
+		}
+		return nil, nil
+	case string:
+		includes = []string{v}
+	case []interface{}:
+		includes = toStrings(v)
+	case map[string]interface{}:
+		includes = toStrings(v["includes"])
+		excludes = toStrings(v["excludes"])
+	}
+
+	filtered := make(map[string]interface{})
+	for key, value := range doc.fields {
+		if len(includes) > 0 && !matchAnyField(includes, key) {
+			continue
+		}
+		if matchAnyField(excludes, key) {
+			continue
+		}
+		filtered[key] = value
+	}
+	return json.Marshal(filtered)
+}
+
+// matchAnyField 只比较第一层字段，includes 中的 a.b 会保留整个 a
+func matchAnyField(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if i := strings.Index(pattern, "."); i >= 0 {
+			pattern = pattern[:i]
+		}
+		if pattern == key || pattern == "*" ||
+			(strings.HasSuffix(pattern, "*") && strings.HasPrefix(key, strings.TrimSuffix(pattern, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+func toStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+// Package estest 提供一个内存中的 es 服务，用于在没有集群的环境中测试 es 相关代码
+//
+//	server := estest.NewServer()
+//	defer server.Close()
+//	esClient := server.Client()
+//	err := es.PerformESInsert("products", documents, esClient)
+//
+// 支持的接口：
+//   - 索引：创建、删除、是否存在、_mapping、_settings、_refresh
+//   - 别名：_aliases、_alias
+//   - 文档：_bulk（index、create、update、delete）、_doc
+//   - 查询：_search（scroll）、_search/scroll、_count、_msearch
+//
+// 查询支持 match_all、ids、term、terms、match、match_phrase、range、exists、prefix、wildcard、bool，
+// 以及 from、size、sort、_source，不支持聚合与评分，所有命中文档的 _score 都为 1
+package estest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/elastic/go-elasticsearch/v7"
+)
+
+// Server 内存中的 es 服务
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	indices  map[string]*index
+	aliases  map[string]map[string]bool // alias -> indices
+	scrolls  map[string]*scroll
+	sequence int
+}
+
+type index struct {
+	name     string
+	mappings json.RawMessage
+	settings json.RawMessage
+	docs     map[string]*document
+	order    []string // 文档写入顺序，没有 sort 时按写入顺序返回
+}
+
+type document struct {
+	id      string
+	version int64
+	source  json.RawMessage
+	fields  map[string]interface{}
+}
+
+type scroll struct {
+	hits  []hit
+	size  int
+	total int // 第一次查询时命中的文档总数，每一页都返回该值
+}
+
+// NewServer 启动服务，使用完后需要调用 Close
+func NewServer() *Server {
+	s := &Server{
+		indices: make(map[string]*index),
+		aliases: make(map[string]map[string]bool),
+		scrolls: make(map[string]*scroll),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Client 返回连接到该服务的客户端，客户端不会重试失败的请求
+func (s *Server) Client() *elasticsearch.Client {
+	esClient, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses:    []string{s.URL},
+		DisableRetry: true,
+	})
+	if err != nil {
+		// 配置是固定的，不会出错
+		panic(err)
+	}
+	return esClient
+}
+
+// Indices 当前所有索引的名称
+func (s *Server) Indices() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.indices))
+	for name := range s.indices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Documents 返回索引中所有文档的 _source，key 为 _id，索引不存在时返回空
+func (s *Server) Documents(name string) map[string]json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := s.indices[name]
+	if idx == nil {
+		return nil
+	}
+	docs := make(map[string]json.RawMessage, len(idx.docs))
+	for id, doc := range idx.docs {
+		docs[id] = doc.source
+	}
+	return docs
+}
+
+// Put 直接写入文档，用于准备测试数据，索引不存在时自动创建
+func (s *Server) Put(name string, id string, source interface{}) error {
+	raw, err := json.Marshal(source)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.indexOrCreate(name).put(id, raw)
+	return err
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := strings.FieldsFunc(r.URL.Path, func(c rune) bool { return c == '/' })
+	status, body := s.route(r, parts)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead && body != nil {
+		_ = json.NewEncoder(w).Encode(body)
+	}
+}
+
+func (s *Server) route(r *http.Request, parts []string) (int, interface{}) {
+	method := r.Method
+	switch len(parts) {
+	case 0:
+		return http.StatusOK, map[string]interface{}{
+			"cluster_name": "estest",
+			"version":      map[string]interface{}{"number": "7.12.0"},
+			"tagline":      "You Know, for Search",
+		}
+	case 1:
+		switch parts[0] {
+		case "_search":
+			return s.handleSearch(r, "")
+		case "_count":
+			return s.handleCount(r, "")
+		case "_bulk":
+			return s.handleBulk(r, "")
+		case "_msearch":
+			return s.handleMultiSearch(r, "")
+		case "_aliases":
+			return s.handleUpdateAliases(r)
+		case "_refresh":
+			return http.StatusOK, shardsOK()
+		}
+		if strings.HasPrefix(parts[0], "_") {
+			break
+		}
+		switch method {
+		case http.MethodPut:
+			return s.handleCreateIndex(r, parts[0])
+		case http.MethodDelete:
+			return s.handleDeleteIndex(parts[0])
+		case http.MethodHead:
+			if _, err := s.resolve(parts[0]); err != nil {
+				return http.StatusNotFound, nil
+			}
+			return http.StatusOK, nil
+		}
+	case 2:
+		if parts[0] == "_search" && parts[1] == "scroll" {
+			if method == http.MethodDelete {
+				return s.handleClearScroll(r, "")
+			}
+			return s.handleScroll(r)
+		}
+		if parts[0] == "_alias" {
+			return s.handleGetAlias("", parts[1])
+		}
+		switch parts[1] {
+		case "_search":
+			return s.handleSearch(r, parts[0])
+		case "_count":
+			return s.handleCount(r, parts[0])
+		case "_bulk":
+			return s.handleBulk(r, parts[0])
+		case "_msearch":
+			return s.handleMultiSearch(r, parts[0])
+		case "_refresh":
+			if _, err := s.resolve(parts[0]); err != nil {
+				return errorResponse(err)
+			}
+			return http.StatusOK, shardsOK()
+		case "_mapping", "_settings":
+			return s.handleUpdateIndex(r, parts[0], parts[1])
+		case "_alias":
+			return s.handleGetAlias(parts[0], "")
+		}
+	case 3:
+		if parts[0] == "_search" && parts[1] == "scroll" && method == http.MethodDelete {
+			return s.handleClearScroll(r, parts[2])
+		}
+		switch parts[1] {
+		case "_doc":
+			if method == http.MethodGet || method == http.MethodHead {
+				return s.handleGetDocument(parts[0], parts[2])
+			}
+		case "_alias":
+			return s.handleGetAlias(parts[0], parts[2])
+		}
+	}
+	return http.StatusBadRequest, errorBody("illegal_argument_exception",
+		"no handler found for uri ["+r.URL.Path+"] and method ["+method+"]", "")
+}
+
+// resolve 将索引名、别名、逗号分隔的列表、_all 以及 * 通配符转换为索引
+func (s *Server) resolve(expr string) ([]*index, error) {
+	if expr == "" || expr == "_all" || expr == "*" {
+		return s.sortedIndices(func(string) bool { return true }), nil
+	}
+	seen := make(map[string]bool)
+	var result []*index
+	add := func(idx *index) {
+		if !seen[idx.name] {
+			seen[idx.name] = true
+			result = append(result, idx)
+		}
+	}
+	for _, name := range strings.Split(expr, ",") {
+		if strings.HasSuffix(name, "*") {
+			prefix := strings.TrimSuffix(name, "*")
+			for _, idx := range s.sortedIndices(func(n string) bool { return strings.HasPrefix(n, prefix) }) {
+				add(idx)
+			}
+			continue
+		}
+		if idx, ok := s.indices[name]; ok {
+			add(idx)
+			continue
+		}
+		members, ok := s.aliases[name]
+		if !ok || len(members) == 0 {
+			return nil, indexNotFound(name)
+		}
+		for _, idx := range s.sortedIndices(func(n string) bool { return members[n] }) {
+			add(idx)
+		}
+	}
+	return result, nil
+}
+
+// resolveWrite 写入时的索引，别名必须只指向一个索引，索引不存在时自动创建
+func (s *Server) resolveWrite(name string) (*index, error) {
+	if members, ok := s.aliases[name]; ok && s.indices[name] == nil {
+		if len(members) != 1 {
+			return nil, &apiError{status: http.StatusBadRequest, kind: "illegal_argument_exception",
+				reason: "no write index is defined for alias [" + name + "]", index: name}
+		}
+		for member := range members {
+			return s.indices[member], nil
+		}
+	}
+	return s.indexOrCreate(name), nil
+}
+
+func (s *Server) indexOrCreate(name string) *index {
+	idx := s.indices[name]
+	if idx == nil {
+		idx = newIndex(name)
+		s.indices[name] = idx
+	}
+	return idx
+}
+
+func (s *Server) sortedIndices(match func(string) bool) []*index {
+	names := make([]string, 0, len(s.indices))
+	for name := range s.indices {
+		if match(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	result := make([]*index, 0, len(names))
+	for _, name := range names {
+		result = append(result, s.indices[name])
+	}
+	return result
+}
+
+func (s *Server) nextID() string {
+	s.sequence++
+	return strconv.Itoa(s.sequence)
+}
+
+func newIndex(name string) *index {
+	return &index{name: name, docs: make(map[string]*document)}
+}
+
+// put 写入文档，返回 created 或 updated
+func (idx *index) put(id string, source json.RawMessage) (*document, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(source, &fields); err != nil {
+		return nil, &apiError{status: http.StatusBadRequest, kind: "mapper_parsing_exception",
+			reason: "failed to parse: " + err.Error(), index: idx.name}
+	}
+	doc := idx.docs[id]
+	if doc == nil {
+		doc = &document{id: id}
+		idx.docs[id] = doc
+		idx.order = append(idx.order, id)
+	}
+	doc.version++
+	doc.source = source
+	doc.fields = fields
+	return doc, nil
+}
+
+func (idx *index) remove(id string) *document {
+	doc := idx.docs[id]
+	if doc == nil {
+		return nil
+	}
+	delete(idx.docs, id)
+	for i, docID := range idx.order {
+		if docID == id {
+			idx.order = append(idx.order[:i], idx.order[i+1:]...)
+			break
+		}
+	}
+	return doc
+}
+
+// apiError es 格式的错误响应
+type apiError struct {
+	status int
+	kind   string
+	reason string
+	index  string
+}
+
+func (e *apiError) Error() string {
+	return e.kind + ": " + e.reason
+}
+
+func indexNotFound(name string) *apiError {
+	return &apiError{status: http.StatusNotFound, kind: "index_not_found_exception",
+		reason: "no such index [" + name + "]", index: name}
+}
+
+func badRequest(reason string) *apiError {
+	return &apiError{status: http.StatusBadRequest, kind: "parsing_exception", reason: reason}
+}
+
+func errorBody(kind string, reason string, index string) map[string]interface{} {
+	cause := map[string]interface{}{"type": kind, "reason": reason}
+	if index != "" {
+		cause["index"] = index
+	}
+	e := map[string]interface{}{"root_cause": []interface{}{cause}}
+	for k, v := range cause {
+		e[k] = v
+	}
+	return map[string]interface{}{"error": e}
+}
+
+func errorResponse(err error) (int, interface{}) {
+	e, ok := err.(*apiError)
+	if !ok {
+		e = &apiError{status: http.StatusInternalServerError, kind: "exception", reason: err.Error()}
+	}
+	body := errorBody(e.kind, e.reason, e.index)
+	body["status"] = e.status
+	return e.status, body
+}
+
+func shardsOK() map[string]interface{} {
+	return map[string]interface{}{"_shards": map[string]interface{}{"total": 1, "successful": 1, "failed": 0}}
+}
+
+func acknowledged() map[string]interface{} {
+	return map[string]interface{}{"acknowledged": true}
+}
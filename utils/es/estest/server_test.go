@@ -0,0 +1,108 @@
+package estest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+func decodeResponse(t *testing.T, res *esapi.Response, err error, v interface{}) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		t.Fatalf("unexpected error response %s", res.String())
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScrollTotal(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	esClient := server.Client()
+
+	for i := 0; i < 5; i++ {
+		if err := server.Put("products", fmt.Sprint(i), map[string]int{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var page struct {
+		ScrollID string `json:"_scroll_id"`
+		Hits     struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+			Hits []json.RawMessage `json:"hits"`
+		} `json:"hits"`
+	}
+	res, err := esClient.Search(esClient.Search.WithIndex("products"), esClient.Search.WithSize(2), esClient.Search.WithScroll(60e9))
+	decodeResponse(t, res, err, &page)
+
+	// 每一页的 total 都是第一次查询时的命中总数
+	for pages := 1; ; pages++ {
+		if page.Hits.Total.Value != 5 {
+			t.Fatalf("page %d: expected total 5, got %d", pages, page.Hits.Total.Value)
+		}
+		if len(page.Hits.Hits) == 0 {
+			break
+		}
+		scrollID := page.ScrollID
+		page.Hits.Hits = nil
+		res, err := esClient.Scroll(esClient.Scroll.WithScrollID(scrollID))
+		decodeResponse(t, res, err, &page)
+	}
+}
+
+func TestAliasNameConflict(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	esClient := server.Client()
+
+	for _, name := range []string{"products", "products_v1"} {
+		res, err := esClient.Indices.Create(name)
+		decodeResponse(t, res, err, &map[string]interface{}{})
+	}
+
+	tests := []struct {
+		name    string
+		actions string
+		status  int
+	}{
+		{name: "alias is index", actions: `{"add": {"index": "products_v1", "alias": "products"}}`, status: 400},
+		{name: "remove index in same request", actions: `{"add": {"index": "products_v1", "alias": "products"}}, {"remove_index": {"index": "products"}}`, status: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := esClient.Indices.UpdateAliases(strings.NewReader(`{"actions": [` + tt.actions + `]}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, res.StatusCode)
+			}
+		})
+	}
+
+	if indices := server.Indices(); len(indices) != 1 || indices[0] != "products_v1" {
+		t.Fatalf("expected only products_v1, got %v", indices)
+	}
+
+	// 创建索引时的别名同样不能与索引同名
+	res, err := esClient.Indices.Create("products_v2", esClient.Indices.Create.WithBody(strings.NewReader(`{"aliases": {"products_v1": {}}}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 400 {
+		t.Fatalf("expected status 400, got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,227 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/pkg/errors"
+)
+
+// IndexDefinition 创建索引时的请求体
+type IndexDefinition struct {
+	Settings map[string]interface{} `json:"settings,omitempty"`
+	Mappings interface{}            `json:"mappings,omitempty"`
+	Aliases  map[string]interface{} `json:"aliases,omitempty"`
+}
+
+// AliasAction 别名操作，用于 UpdateAliases
+type AliasAction struct {
+	Action string // add、remove、remove_index
+	Index  string
+	Alias  string
+	// IsWriteIndex 别名指向多个索引时，写入使用的索引
+	IsWriteIndex *bool
+}
+
+func (a AliasAction) body() map[string]interface{} {
+	action := map[string]interface{}{"index": a.Index}
+	if a.Alias != "" {
+		action["alias"] = a.Alias
+	}
+	if a.IsWriteIndex != nil {
+		action["is_write_index"] = *a.IsWriteIndex
+	}
+	return map[string]interface{}{a.Action: action}
+}
+
+// CreateIndex 创建索引，body 可以是 IndexDefinition、map 等会被 json 序列化的结构，
+// 也可以是 string、[]byte、json.RawMessage 形式的 JSON
+func CreateIndex(ctx context.Context, index string, body interface{}, esClient *elasticsearch.Client) error {
+	reqBody, err := encodeBody(body)
+	if err != nil {
+		return err
+	}
+	return doRequest(ctx, esClient, nil, func() esapi.Request {
+		return esapi.IndicesCreateRequest{
+			Index: index,
+			Body:  bodyReader(reqBody),
+		}
+	})
+}
+
+// IndexExists 索引或别名是否存在
+func IndexExists(ctx context.Context, index string, esClient *elasticsearch.Client) (bool, error) {
+	req := esapi.IndicesExistsRequest{
+		Index: []string{index},
+	}
+	res, err := req.Do(ctx, esClient)
+	if err != nil {
+		return false, errors.Wrap(err, "Error getting response")
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == 404:
+		return false, nil
+	case res.IsError():
+		return false, responseError(res)
+	}
+	return true, nil
+}
+
+// PutMapping 为已有索引添加字段，已有字段的类型不能修改
+func PutMapping(ctx context.Context, index string, mapping interface{}, esClient *elasticsearch.Client) error {
+	reqBody, err := encodeBody(mapping)
+	if err != nil {
+		return err
+	}
+	return doRequest(ctx, esClient, nil, func() esapi.Request {
+		return esapi.IndicesPutMappingRequest{
+			Index: []string{index},
+			Body:  bodyReader(reqBody),
+		}
+	})
+}
+
+// UpdateSettings 修改索引的动态配置，如 {"index": {"number_of_replicas": 1, "refresh_interval": "1s"}}
+func UpdateSettings(ctx context.Context, index string, settings interface{}, esClient *elasticsearch.Client) error {
+	reqBody, err := encodeBody(settings)
+	if err != nil {
+		return err
+	}
+	return doRequest(ctx, esClient, nil, func() esapi.Request {
+		return esapi.IndicesPutSettingsRequest{
+			Index: []string{index},
+			Body:  bodyReader(reqBody),
+		}
+	})
+}
+
+// AddAlias 为索引添加别名
+func AddAlias(ctx context.Context, index string, alias string, esClient *elasticsearch.Client) error {
+	return UpdateAliases(ctx, esClient, AliasAction{Action: "add", Index: index, Alias: alias})
+}
+
+// RemoveAlias 删除索引的别名
+func RemoveAlias(ctx context.Context, index string, alias string, esClient *elasticsearch.Client) error {
+	return UpdateAliases(ctx, esClient, AliasAction{Action: "remove", Index: index, Alias: alias})
+}
+
+// UpdateAliases 在一个请求中原子地执行多个别名操作
+func UpdateAliases(ctx context.Context, esClient *elasticsearch.Client, actions ...AliasAction) error {
+	if len(actions) == 0 {
+		return nil
+	}
+	bodyActions := make([]interface{}, 0, len(actions))
+	for _, action := range actions {
+		bodyActions = append(bodyActions, action.body())
+	}
+	reqBody, err := json.Marshal(map[string]interface{}{"actions": bodyActions})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return doRequest(ctx, esClient, nil, func() esapi.Request {
+		return esapi.IndicesUpdateAliasesRequest{
+			Body: bodyReader(reqBody),
+		}
+	})
+}
+
+// GetAliasIndices 返回别名当前指向的索引，别名不存在时返回空
+func GetAliasIndices(ctx context.Context, alias string, esClient *elasticsearch.Client) ([]string, error) {
+	req := esapi.IndicesGetAliasRequest{
+		Name: []string{alias},
+	}
+	res, err := req.Do(ctx, esClient)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error getting response")
+	}
+	if res.StatusCode == 404 {
+		res.Body.Close()
+		return nil, nil
+	}
+	var r map[string]json.RawMessage
+	if err := decodeResponse(res, &r); err != nil {
+		return nil, err
+	}
+	indices := make([]string, 0, len(r))
+	for index := range r {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+	return indices, nil
+}
+
+// SwapAlias 将别名原子地切换到 index，并返回别名之前指向的索引
+// 别名不存在时直接创建，用于重建索引后零停机切换
+func SwapAlias(ctx context.Context, alias string, index string, esClient *elasticsearch.Client) ([]string, error) {
+	oldIndices, err := GetAliasIndices(ctx, alias, esClient)
+	if err != nil {
+		return nil, err
+	}
+	actions := []AliasAction{{Action: "add", Index: index, Alias: alias}}
+	previous := make([]string, 0, len(oldIndices))
+	for _, oldIndex := range oldIndices {
+		if oldIndex == index {
+			continue
+		}
+		actions = append(actions, AliasAction{Action: "remove", Index: oldIndex, Alias: alias})
+		previous = append(previous, oldIndex)
+	}
+	if err := UpdateAliases(ctx, esClient, actions...); err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+// doRequest 发送 newRequest 创建的请求，按重试策略重试，v 不为空时解析响应体
+// 每次重试都会重新调用 newRequest，保证请求体可以重复读取
+func doRequest(ctx context.Context, esClient *elasticsearch.Client, v interface{}, newRequest func() esapi.Request) error {
+	start := time.Now()
+	var req esapi.Request
+	res, err := performWithRetry(ctx, func() (*esapi.Response, error) {
+		req = newRequest()
+		return req.Do(ctx, esClient)
+	})
+	switch {
+	case err != nil:
+	case v == nil:
+		res.Body.Close()
+	default:
+		err = decodeResponse(res, v)
+	}
+	logRequest(requestMethod(req), requestIndex(req), start, nil, nil, err)
+	return err
+}
+
+// encodeBody 将请求体序列化为 JSON，string、[]byte、json.RawMessage 直接使用
+func encodeBody(body interface{}) ([]byte, error) {
+	switch b := body.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(b), nil
+	case []byte:
+		return b, nil
+	case json.RawMessage:
+		return b, nil
+	}
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode request body failed")
+	}
+	return reqBody, nil
+}
+
+// bodyReader 请求体为空时返回 nil，避免发送空的请求体
+func bodyReader(body []byte) io.Reader {
+	if body == nil {
+		return nil
+	}
+	return bytes.NewReader(body)
+}
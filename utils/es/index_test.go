@@ -0,0 +1,172 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestSwapAlias(t *testing.T) {
+	server, esClient := newTestServer(t)
+	defer server.Close()
+
+	ctx := context.Background()
+	for _, index := range []string{"products_v1", "products_v2"} {
+		if err := CreateIndex(ctx, index, nil, esClient); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previous, err := SwapAlias(ctx, "products", "products_v1", esClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(previous) != 0 {
+		t.Fatalf("expected no previous indices, got %v", previous)
+	}
+
+	previous, err = SwapAlias(ctx, "products", "products_v2", esClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(previous, []string{"products_v1"}) {
+		t.Fatalf("expected [products_v1], got %v", previous)
+	}
+	indices, err := GetAliasIndices(ctx, "products", esClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(indices, []string{"products_v2"}) {
+		t.Fatalf("expected [products_v2], got %v", indices)
+	}
+
+	// 别名不能与索引同名
+	if _, err := SwapAlias(ctx, "products_v1", "products_v2", esClient); err == nil {
+		t.Fatal("expected error when the alias has the same name as an index")
+	}
+}
+
+func TestReindexReplaceIndex(t *testing.T) {
+	server, esClient := newTestServer(t)
+	defer server.Close()
+
+	ctx := context.Background()
+	if err := PerformESInsert("products", testProducts(5), esClient); err != nil {
+		t.Fatal(err)
+	}
+	copyHit := func(hit Hit) (interface{}, bool, error) {
+		return hit.Source, true, nil
+	}
+
+	// 第一次迁移时 products 是索引，没有设置 ReplaceIndex 时在复制前返回错误
+	_, err := Reindex(ctx, "products", "products_%s", ReindexOptions{Version: "v1", Transform: copyHit}, esClient)
+	if err == nil {
+		t.Fatal("expected error when the alias is an existing index")
+	}
+	if indices := server.Indices(); !reflect.DeepEqual(indices, []string{"products"}) {
+		t.Fatalf("new index should not be created, got %v", indices)
+	}
+
+	result, err := Reindex(ctx, "products", "products_%s", ReindexOptions{
+		Version:      "v1",
+		Transform:    copyHit,
+		ReplaceIndex: true,
+	}, esClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Index != "products_v1" || result.SourceCount != 5 || result.DestCount != 5 || !result.DeletedOld {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	// 之后的迁移只切换别名
+	result, err = Reindex(ctx, "products", "products_%s", ReindexOptions{
+		Version:   "v2",
+		Transform: copyHit,
+		DeleteOld: true,
+	}, esClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.PreviousIndices, []string{"products_v1"}) {
+		t.Fatalf("unexpected previous indices %v", result.PreviousIndices)
+	}
+	if indices := server.Indices(); !reflect.DeepEqual(indices, []string{"products_v2"}) {
+		t.Fatalf("expected only products_v2, got %v", indices)
+	}
+	count, err := Count(ctx, "products", nil, esClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 {
+		t.Fatalf("expected 5 documents through the alias, got %d", count)
+	}
+}
+
+func TestReindexCancel(t *testing.T) {
+	server, _ := newTestServer(t)
+	defer server.Close()
+	// bulk 请求延迟 100ms 后再转发给 estest，转发不会因为客户端断开而取消，
+	// 与 es 一样，已经收到的 bulk 总会被执行
+	slow, esClient := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.HasSuffix(r.URL.Path, "/_bulk") {
+			time.Sleep(100 * time.Millisecond)
+		}
+		req, err := http.NewRequest(r.Method, server.URL+r.URL.RequestURI(), bytes.NewReader(body))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		req.Header = r.Header
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer res.Body.Close()
+		w.Header().Set("Content-Type", res.Header.Get("Content-Type"))
+		w.WriteHeader(res.StatusCode)
+		_, _ = io.Copy(w, res.Body)
+	})
+	defer slow.Close()
+
+	if err := PerformESInsert("products", testProducts(200), server.Client()); err != nil {
+		t.Fatal(err)
+	}
+	// 每个文档单独发送，取消时有正在发送的 bulk
+	old := batchSize
+	batchSize = 1
+	defer func() { batchSize = old }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	copied := 0
+	_, err := Reindex(ctx, "products", "products_%s", ReindexOptions{
+		Version:      "v1",
+		ReplaceIndex: true,
+		Transform: func(hit Hit) (interface{}, bool, error) {
+			if copied++; copied == 10 {
+				cancel()
+			}
+			return hit.Source, true, nil
+		},
+	}, esClient)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+
+	// Reindex 返回后不会再有 bulk 写入新索引
+	time.Sleep(time.Second)
+	if indices := server.Indices(); !reflect.DeepEqual(indices, []string{"products"}) {
+		t.Fatalf("expected only products, got %v", indices)
+	}
+}
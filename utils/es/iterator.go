@@ -0,0 +1,383 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	defaultPageSize  = 1000
+	defaultKeepAlive = time.Minute
+)
+
+// Hit es 查询结果中的一条记录
+type Hit struct {
+	Index  string          `json:"_index"`
+	ID     string          `json:"_id"`
+	Score  *float64        `json:"_score"`
+	Source json.RawMessage `json:"_source"`
+	Sort   []interface{}   `json:"sort,omitempty"`
+	// Highlight 高亮结果，key 为字段名
+	Highlight map[string][]string `json:"highlight,omitempty"`
+}
+
+// Iterator 逐条遍历查询结果，ScrollIterator 与 SearchAfterIterator 均实现了该接口
+//
+//	it := es.NewIterator(query, index, esClient, es.WithPagination(es.PaginationSearchAfter))
+//	defer it.Close()
+//	for it.Next(ctx) {
+//		var doc Document
+//		if err := it.Hit(&doc); err != nil {
+//			...
+//		}
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator interface {
+	// Next 移动到下一条记录，当前页读完时自动拉取下一页。没有更多记录或出错时返回 false
+	Next(ctx context.Context) bool
+	// Hit 将当前记录的 _source 解析到 v，v 必须是指针
+	Hit(v interface{}) error
+	// RawHit 返回当前记录的原始内容，包含 _id、_index 等元信息
+	RawHit() Hit
+	// Err 返回遍历过程中遇到的错误
+	Err() error
+	// Close 释放服务端的 scroll / point in time 上下文，可重复调用
+	Close() error
+}
+
+// Pagination 翻页方式
+type Pagination int
+
+// PaginationScroll 使用 scroll 翻页
+// PaginationSearchAfter 使用 point in time + search_after 翻页，适合深度翻页，需要 es 7.10 及以上版本
+const (
+	PaginationScroll Pagination = iota
+	PaginationSearchAfter
+)
+
+type iteratorConfig struct {
+	pageSize   int
+	keepAlive  time.Duration
+	pagination Pagination
+	tiebreaker string
+}
+
+// IteratorOption 遍历器配置项
+type IteratorOption func(*iteratorConfig)
+
+// WithPageSize 每页拉取的文档数，会覆盖 query 中的 size
+func WithPageSize(size int) IteratorOption {
+	return func(c *iteratorConfig) {
+		c.pageSize = size
+	}
+}
+
+// WithKeepAlive scroll / point in time 上下文的保持时间，默认 1 分钟，每次翻页时续期
+func WithKeepAlive(keepAlive time.Duration) IteratorOption {
+	return func(c *iteratorConfig) {
+		c.keepAlive = keepAlive
+	}
+}
+
+// WithPagination 指定 NewIterator 使用的翻页方式，默认 PaginationScroll
+func WithPagination(pagination Pagination) IteratorOption {
+	return func(c *iteratorConfig) {
+		c.pagination = pagination
+	}
+}
+
+// WithTiebreaker search_after 翻页时追加到 sort 末尾的唯一字段，默认 _shard_doc（es 7.12 及以上）
+func WithTiebreaker(field string) IteratorOption {
+	return func(c *iteratorConfig) {
+		c.tiebreaker = field
+	}
+}
+
+func newIteratorConfig(query map[string]interface{}, opts []IteratorOption) iteratorConfig {
+	config := iteratorConfig{
+		pageSize:   defaultPageSize,
+		keepAlive:  defaultKeepAlive,
+		pagination: PaginationScroll,
+		tiebreaker: "_shard_doc",
+	}
+	if size, ok := querySize(query); ok && size > 0 {
+		config.pageSize = size
+	}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return config
+}
+
+// NewIterator 根据 WithPagination 创建对应的遍历器，第一次调用 Next 时才会发起查询
+func NewIterator(query map[string]interface{}, index string, esClient *elasticsearch.Client, opts ...IteratorOption) Iterator {
+	config := newIteratorConfig(query, opts)
+	if config.pagination == PaginationSearchAfter {
+		return NewSearchAfterIterator(query, index, esClient, opts...)
+	}
+	return NewScrollIterator(query, index, esClient, opts...)
+}
+
+// pager 负责拉取下一页以及释放服务端上下文
+type pager interface {
+	fetch(ctx context.Context) ([]Hit, error)
+	release() error
+}
+
+// hitCursor 保存当前页的结果，实现两种遍历器共有的逻辑
+type hitCursor struct {
+	pageSize int
+	hits     []Hit
+	pos      int
+	lastPage bool
+	done     bool
+	err      error
+}
+
+func (c *hitCursor) next(ctx context.Context, p pager) bool {
+	if c.done || c.err != nil {
+		return false
+	}
+	c.pos++
+	if c.pos < len(c.hits) {
+		return true
+	}
+	if c.lastPage {
+		c.finish(p)
+		return false
+	}
+
+	hits, err := p.fetch(ctx)
+	if err != nil {
+		c.err = err
+		c.finish(p)
+		return false
+	}
+	c.hits = hits
+	c.pos = 0
+	if len(hits) < c.pageSize {
+		c.lastPage = true
+	}
+	if len(hits) == 0 {
+		c.finish(p)
+		return false
+	}
+	return true
+}
+
+func (c *hitCursor) finish(p pager) {
+	c.done = true
+	c.hits = nil
+	c.pos = -1
+	if err := p.release(); err != nil && c.err == nil {
+		c.err = err
+	}
+}
+
+// Hit 将当前记录的 _source 解析到 v，v 必须是指针
+func (c *hitCursor) Hit(v interface{}) error {
+	if c.pos < 0 || c.pos >= len(c.hits) {
+		return errors.New("no current hit, call Next first")
+	}
+	if err := json.Unmarshal(c.hits[c.pos].Source, v); err != nil {
+		return errors.Wrapf(err, "decode hit %s failed", c.hits[c.pos].ID)
+	}
+	return nil
+}
+
+// RawHit 返回当前记录的原始内容，包含 _id、_index 等元信息
+func (c *hitCursor) RawHit() Hit {
+	if c.pos < 0 || c.pos >= len(c.hits) {
+		return Hit{}
+	}
+	return c.hits[c.pos]
+}
+
+// Err 返回遍历过程中遇到的错误
+func (c *hitCursor) Err() error {
+	return c.err
+}
+
+type scrollResponse struct {
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []Hit `json:"hits"`
+	} `json:"hits"`
+}
+
+// ScrollIterator 基于 scroll 逐条遍历查询结果，自动翻页，结束、出错或 Close 时清理 scroll 上下文
+type ScrollIterator struct {
+	hitCursor
+
+	esClient  *elasticsearch.Client
+	index     string
+	query     map[string]interface{}
+	keepAlive time.Duration
+	scrollID  string
+	started   bool
+}
+
+// NewScrollIterator 创建 scroll 遍历器，第一次调用 Next 时才会发起查询
+func NewScrollIterator(query map[string]interface{}, index string, esClient *elasticsearch.Client, opts ...IteratorOption) *ScrollIterator {
+	config := newIteratorConfig(query, opts)
+
+	body := copyQuery(query)
+	body["size"] = config.pageSize
+
+	return &ScrollIterator{
+		hitCursor: hitCursor{pageSize: config.pageSize, pos: -1},
+		esClient:  esClient,
+		index:     index,
+		query:     body,
+		keepAlive: config.keepAlive,
+	}
+}
+
+// Next 移动到下一条记录，当前页读完时自动拉取下一页。没有更多记录或出错时返回 false
+func (it *ScrollIterator) Next(ctx context.Context) bool {
+	return it.next(ctx, it)
+}
+
+// Close 清理 scroll 上下文，可重复调用
+func (it *ScrollIterator) Close() error {
+	it.done = true
+	return it.release()
+}
+
+func (it *ScrollIterator) fetch(ctx context.Context) ([]Hit, error) {
+	var (
+		page *scrollResponse
+		err  error
+	)
+	if !it.started {
+		it.started = true
+		page, err = it.search(ctx)
+	} else {
+		page, err = it.scroll(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if page.ScrollID != "" {
+		it.scrollID = page.ScrollID
+	}
+	return page.Hits.Hits, nil
+}
+
+func (it *ScrollIterator) search(ctx context.Context) (*scrollResponse, error) {
+	reqBody, err := json.Marshal(it.query)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode query failed")
+	}
+	start := time.Now()
+	res, err := performWithRetry(ctx, func() (*esapi.Response, error) {
+		return it.esClient.Search(
+			it.esClient.Search.WithContext(ctx),
+			it.esClient.Search.WithIndex(it.index),
+			it.esClient.Search.WithBody(bytes.NewReader(reqBody)),
+			it.esClient.Search.WithScroll(it.keepAlive),
+		)
+	})
+	var page scrollResponse
+	if err == nil {
+		err = decodeResponse(res, &page)
+	}
+	logRequest("Search", it.index, start, reqBody, logrus.Fields{"hits": len(page.Hits.Hits)}, err)
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func (it *ScrollIterator) scroll(ctx context.Context) (*scrollResponse, error) {
+	start := time.Now()
+	res, err := performWithRetry(ctx, func() (*esapi.Response, error) {
+		return it.esClient.Scroll(
+			it.esClient.Scroll.WithContext(ctx),
+			it.esClient.Scroll.WithScrollID(it.scrollID),
+			it.esClient.Scroll.WithScroll(it.keepAlive),
+		)
+	})
+	var page scrollResponse
+	if err == nil {
+		err = decodeResponse(res, &page)
+	}
+	logRequest("Scroll", it.index, start, nil, logrus.Fields{"hits": len(page.Hits.Hits)}, err)
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func (it *ScrollIterator) release() error {
+	if it.scrollID == "" {
+		return nil
+	}
+	scrollID := it.scrollID
+	it.scrollID = ""
+
+	res, err := it.esClient.ClearScroll(
+		it.esClient.ClearScroll.WithContext(context.Background()),
+		it.esClient.ClearScroll.WithScrollID(scrollID),
+	)
+	if err != nil {
+		return errors.Wrap(err, "clear scroll failed")
+	}
+	defer res.Body.Close()
+	// scroll 已过期时返回 404，无需处理
+	if res.IsError() && res.StatusCode != 404 {
+		return responseError(res)
+	}
+	return nil
+}
+
+// decodeResponse 检查响应状态并将响应体解析到 v，会关闭响应体
+func decodeResponse(res *esapi.Response, v interface{}) error {
+	defer res.Body.Close()
+	if res.IsError() {
+		return responseError(res)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return errors.Wrap(err, "Error parsing the response body")
+	}
+	return nil
+}
+
+// copyQuery 浅拷贝 query，避免修改调用方的 map
+func copyQuery(query map[string]interface{}) map[string]interface{} {
+	body := make(map[string]interface{}, len(query)+4)
+	for k, v := range query {
+		body[k] = v
+	}
+	return body
+}
+
+// querySize 读取 query 中的 size，兼容 int 以及从 JSON 解析出的 float64 等类型
+func querySize(query map[string]interface{}) (int, bool) {
+	switch size := query["size"].(type) {
+	case int:
+		return size, true
+	case int32:
+		return int(size), true
+	case int64:
+		return int(size), true
+	case float64:
+		return int(size), true
+	case json.Number:
+		n, err := size.Int64()
+		if err != nil {
+			return 0, false
+		}
+		return int(n), true
+	}
+	return 0, false
+}
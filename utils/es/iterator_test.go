@@ -0,0 +1,74 @@
+package es
+
+import (
+	"context"
+	"testing"
+)
+
+func TestScrollIterator(t *testing.T) {
+	server, esClient := newTestServer(t)
+	defer server.Close()
+
+	if err := PerformESInsert("products", testProducts(25), esClient); err != nil {
+		t.Fatal(err)
+	}
+
+	it := NewScrollIterator(map[string]interface{}{}, "products", esClient, WithPageSize(10))
+	seen := make(map[string]bool)
+	for it.Next(context.Background()) {
+		var product testProduct
+		if err := it.Hit(&product); err != nil {
+			t.Fatal(err)
+		}
+		if seen[product.ID] {
+			t.Fatalf("document %s returned twice", product.ID)
+		}
+		seen[product.ID] = true
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 25 {
+		t.Fatalf("expected 25 documents, got %d", len(seen))
+	}
+}
+
+func TestScrollIteratorQuery(t *testing.T) {
+	server, esClient := newTestServer(t)
+	defer server.Close()
+
+	if err := PerformESInsert("products", testProducts(25), esClient); err != nil {
+		t.Fatal(err)
+	}
+
+	query := map[string]interface{}{
+		"query": NewRangeQuery("price").Gt(20).Map(),
+		"sort":  []interface{}{map[string]interface{}{"price": "desc"}},
+	}
+	it := NewIterator(query, "products", esClient, WithPageSize(2))
+	defer it.Close()
+
+	var prices []float64
+	for it.Next(context.Background()) {
+		var product testProduct
+		if err := it.Hit(&product); err != nil {
+			t.Fatal(err)
+		}
+		prices = append(prices, product.Price)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{25, 24, 23, 22, 21}
+	if len(prices) != len(want) {
+		t.Fatalf("expected %v, got %v", want, prices)
+	}
+	for i := range want {
+		if prices[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, prices)
+		}
+	}
+}
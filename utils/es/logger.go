@@ -0,0 +1,140 @@
+package es
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	cfg "github.com/yzlq99/go_utils/utils/config"
+)
+
+var (
+	loggerMu      sync.RWMutex
+	logger        = NewLogger(cfg.LevelWarn)
+	slowThreshold = time.Second
+)
+
+// NewLogger 按 cfg.LevelMode 创建 logger
+// debug 记录每个请求的方法、索引、耗时、命中数以及状态码，trace 额外记录请求体
+func NewLogger(level cfg.LevelMode) *logrus.Logger {
+	l := logrus.New()
+	l.SetLevel(level.Level())
+	return l
+}
+
+// SetLogger 设置 es 请求使用的 logger，为空时关闭日志，可以与请求并发调用
+//
+//	es.SetLogger(es.NewLogger(config.LevelMode))
+func SetLogger(l *logrus.Logger) {
+	if l == nil {
+		l = logrus.New()
+		l.SetOutput(ioutil.Discard)
+		l.SetLevel(logrus.PanicLevel)
+	}
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = l
+}
+
+// SetSlowThreshold 耗时超过 threshold 的请求以 warn 级别记录，为 0 时不记录慢请求
+func SetSlowThreshold(threshold time.Duration) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	slowThreshold = threshold
+}
+
+// currentLogger 返回当前的 logger
+func currentLogger() *logrus.Logger {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return logger
+}
+
+func currentSlowThreshold() time.Duration {
+	loggerMu.RLock()
+	defer loggerMu.RUnlock()
+	return slowThreshold
+}
+
+// logRequest 记录一次请求的结果，耗时包含重试的时间
+// 请求体可能包含文档内容，只在 trace 级别记录
+func logRequest(method string, index string, start time.Time, body []byte, fields logrus.Fields, err error) {
+	duration := time.Since(start)
+	l := currentLogger()
+	threshold := currentSlowThreshold()
+	slow := threshold > 0 && duration >= threshold
+	if !slow && !l.IsLevelEnabled(logrus.DebugLevel) {
+		return
+	}
+
+	entry := l.WithFields(logrus.Fields{
+		"method":   method,
+		"duration": duration.String(),
+		"status":   responseStatus(err),
+	})
+	if index != "" {
+		entry = entry.WithField("index", index)
+	}
+	if len(fields) > 0 {
+		entry = entry.WithFields(fields)
+	}
+	if len(body) > 0 && l.IsLevelEnabled(logrus.TraceLevel) {
+		entry = entry.WithField("body", string(body))
+	}
+	if err != nil {
+		entry = entry.WithError(err)
+	}
+
+	switch {
+	case slow:
+		entry.Warn("es slow request")
+	case err != nil:
+		entry.Debug("es request failed")
+	default:
+		entry.Debug("es request")
+	}
+}
+
+// responseStatus 请求成功时为 200，es 返回错误时为响应的状态码，请求未发出时为 0
+func responseStatus(err error) int {
+	if err == nil {
+		return 200
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// requestMethod esapi.IndicesCreateRequest 的方法名为 IndicesCreate
+func requestMethod(req esapi.Request) string {
+	t := reflect.TypeOf(req)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return strings.TrimSuffix(t.Name(), "Request")
+}
+
+// requestIndex 读取 esapi 请求中的 Index 字段
+func requestIndex(req esapi.Request) string {
+	v := reflect.Indirect(reflect.ValueOf(req))
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	field := v.FieldByName("Index")
+	switch {
+	case !field.IsValid():
+		return ""
+	case field.Kind() == reflect.String:
+		return field.String()
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		return strings.Join(field.Interface().([]string), ",")
+	}
+	return ""
+}
@@ -0,0 +1,133 @@
+package es
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	cfg "github.com/yzlq99/go_utils/utils/config"
+)
+
+// captureLogs 将 es 日志以 json 格式写入返回的 buffer，测试结束时需要调用 resetLogs
+func captureLogs(t *testing.T, level cfg.LevelMode, threshold time.Duration) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	l := NewLogger(level)
+	l.SetOutput(buf)
+	l.SetFormatter(&logrus.JSONFormatter{})
+	SetLogger(l)
+	SetSlowThreshold(threshold)
+	return buf
+}
+
+// resetLogs 恢复默认的 logger 与慢请求阈值
+func resetLogs() {
+	SetLogger(NewLogger(cfg.LevelWarn))
+	SetSlowThreshold(time.Second)
+}
+
+func logEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLogRequest(t *testing.T) {
+	defer resetLogs()
+	body := []byte(`{"query":{"match_all":{}}}`)
+	tests := []struct {
+		name      string
+		level     cfg.LevelMode
+		threshold time.Duration
+		duration  time.Duration
+		// want 为空时不记录日志
+		want     string
+		wantBody bool
+	}{
+		{name: "warn", level: cfg.LevelWarn, threshold: time.Second, duration: time.Millisecond},
+		{name: "slow", level: cfg.LevelWarn, threshold: time.Second, duration: 2 * time.Second, want: "warning"},
+		{name: "slow disabled", level: cfg.LevelWarn, duration: 2 * time.Second},
+		{name: "debug", level: cfg.LevelDebug, threshold: time.Second, duration: time.Millisecond, want: "debug"},
+		{name: "debug slow", level: cfg.LevelDebug, threshold: time.Second, duration: 2 * time.Second, want: "warning"},
+		{name: "trace", level: cfg.LevelTrace, threshold: time.Second, duration: time.Millisecond, want: "debug", wantBody: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureLogs(t, tt.level, tt.threshold)
+			logRequest("Search", "products", time.Now().Add(-tt.duration), body, logrus.Fields{"hits": 3}, nil)
+
+			entries := logEntries(t, buf)
+			if tt.want == "" {
+				if len(entries) != 0 {
+					t.Fatalf("unexpected logs %v", entries)
+				}
+				return
+			}
+			if len(entries) != 1 {
+				t.Fatalf("expected 1 log, got %v", entries)
+			}
+			entry := entries[0]
+			if entry["level"] != tt.want || entry["method"] != "Search" || entry["index"] != "products" || entry["hits"] != float64(3) {
+				t.Fatalf("unexpected log %v", entry)
+			}
+			if _, ok := entry["body"]; ok != tt.wantBody {
+				t.Fatalf("body logged: %v, expected %v", ok, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestLogRequestBody(t *testing.T) {
+	defer resetLogs()
+	server, esClient := newTestServer(t)
+	defer server.Close()
+
+	for _, level := range []cfg.LevelMode{cfg.LevelDebug, cfg.LevelTrace} {
+		buf := captureLogs(t, level, 0)
+		if err := PerformESIndex("products", testProducts(1), esClient); err != nil {
+			t.Fatal(err)
+		}
+		entries := logEntries(t, buf)
+		if len(entries) == 0 {
+			t.Fatalf("%s: expected logs", level)
+		}
+		// 文档内容只在 trace 级别记录
+		logged := strings.Contains(buf.String(), "product 1")
+		if logged != (level == cfg.LevelTrace) {
+			t.Fatalf("%s: document logged %v", level, logged)
+		}
+	}
+}
+
+func TestSetLoggerConcurrent(t *testing.T) {
+	defer resetLogs()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			SetLogger(nil)
+			SetSlowThreshold(time.Millisecond)
+		}()
+		go func() {
+			defer wg.Done()
+			logRequest("Search", "products", time.Now(), nil, nil, nil)
+		}()
+	}
+	wg.Wait()
+}
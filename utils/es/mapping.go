@@ -0,0 +1,223 @@
+package es
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// GenerateMapping 根据结构体生成索引的 mappings，可以直接作为 IndexDefinition.Mappings 使用
+//
+// 字段名使用 json tag，字段选项使用 es tag：
+//
+//	type Document struct {
+//		ID        string    `json:"id" es:"id,type=keyword"`
+//		Title     string    `json:"title" es:"analyzer=ik_max_word,search_analyzer=ik_smart"`
+//		Content   string    `json:"content" es:"type=text,index=false"`
+//		Tags      []string  `json:"tags" es:"type=keyword"`
+//		Author    Author    `json:"author"`              // object
+//		Comments  []Comment `json:"comments"`            // nested
+//		CreatedAt time.Time `json:"created_at"`          // date
+//		Internal  string    `json:"internal" es:"-"`     // 不生成 mapping
+//	}
+//
+// 没有指定 type 时按 Go 类型推断：string 与 es 的动态 mapping 一致为 text 加 keyword 子字段，
+// 结构体为 object，结构体切片为 nested，time.Time 为 date。
+// type 以外的选项原样写入 mapping，true、false 以及数字会转换为对应的 JSON 类型。
+// 带有 keyword flag 的 text 字段会加上 keyword 子字段。
+// id、routing、version 等文档元信息的 flag 与 mapping 选项可以写在同一个 es tag 中，不影响 mapping，
+// 如上面的 ID 字段为 {"type": "keyword"}。
+func GenerateMapping(document interface{}) (map[string]interface{}, error) {
+	t := reflect.TypeOf(document)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, errors.Errorf("document must be a struct, got %T", document)
+	}
+	properties, err := structProperties(t, map[reflect.Type]bool{})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"properties": properties}, nil
+}
+
+func structProperties(t reflect.Type, visiting map[reflect.Type]bool) (map[string]interface{}, error) {
+	if visiting[t] {
+		return nil, errors.Errorf("recursive type %s can not be mapped", t)
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		jsonName, skip := jsonFieldName(field)
+		if skip {
+			continue
+		}
+		esTag := field.Tag.Get("es")
+		if esTag == "-" {
+			continue
+		}
+
+		// 没有 json 名称的匿名结构体，字段会被展开到上一层
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && jsonName == "" && fieldType.Kind() == reflect.Struct && fieldType != timeType {
+			embedded, err := structProperties(fieldType, visiting)
+			if err != nil {
+				return nil, err
+			}
+			for name, property := range embedded {
+				if _, ok := properties[name]; !ok {
+					properties[name] = property
+				}
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			// 未导出的字段不会被 json 序列化
+			continue
+		}
+		if jsonName == "" {
+			jsonName = field.Name
+		}
+
+		property, err := fieldProperty(field.Type, esTag, visiting)
+		if err != nil {
+			return nil, errors.Wrapf(err, "field %s.%s", t.Name(), field.Name)
+		}
+		if property != nil {
+			properties[jsonName] = property
+		}
+	}
+	return properties, nil
+}
+
+func fieldProperty(t reflect.Type, tag string, visiting map[reflect.Type]bool) (map[string]interface{}, error) {
+	flags, options := parseTag(tag)
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	isSlice := false
+	if (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() != reflect.Uint8 {
+		isSlice = true
+		t = t.Elem()
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+	}
+
+	property := make(map[string]interface{})
+	esType := options["type"]
+	if esType == "" {
+		esType = inferType(t, isSlice)
+	}
+
+	switch esType {
+	case "":
+		// interface{} 等无法推断的类型交给 es 的动态 mapping
+		if len(options) == 0 {
+			return nil, nil
+		}
+	case "object", "nested":
+		if t.Kind() == reflect.Struct && t != timeType {
+			properties, err := structProperties(t, visiting)
+			if err != nil {
+				return nil, err
+			}
+			property["properties"] = properties
+		}
+		// 有 properties 时 object 是默认类型，不需要写出来
+		if _, ok := property["properties"]; !ok || esType == "nested" || options["type"] == "object" {
+			property["type"] = esType
+		}
+	default:
+		property["type"] = esType
+	}
+
+	if esType == "text" && (flags["keyword"] || options["type"] == "") && options["fields"] == "" {
+		property["fields"] = map[string]interface{}{
+			"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 256},
+		}
+	}
+	for key, value := range options {
+		if key == "type" {
+			continue
+		}
+		property[key] = optionValue(value)
+	}
+	return property, nil
+}
+
+func inferType(t reflect.Type, isSlice bool) string {
+	if t == timeType {
+		return "date"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "text"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int8:
+		return "byte"
+	case reflect.Int16:
+		return "short"
+	case reflect.Int32:
+		return "integer"
+	case reflect.Int, reflect.Int64, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint, reflect.Uint64:
+		return "long"
+	case reflect.Float32:
+		return "float"
+	case reflect.Float64:
+		return "double"
+	case reflect.Slice, reflect.Array:
+		// []byte
+		return "binary"
+	case reflect.Map:
+		return "object"
+	case reflect.Struct:
+		if isSlice {
+			return "nested"
+		}
+		return "object"
+	}
+	return ""
+}
+
+// jsonFieldName 返回 json tag 中的字段名，json:"-" 时 skip 为 true
+func jsonFieldName(field reflect.StructField) (name string, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+	if i := strings.Index(tag, ","); i >= 0 {
+		tag = tag[:i]
+	}
+	return tag, false
+}
+
+func optionValue(value string) interface{} {
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	return value
+}
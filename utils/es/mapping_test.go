@@ -0,0 +1,200 @@
+package es
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type mappingAuthor struct {
+	Name string `json:"name" es:"type=keyword"`
+}
+
+type mappingComment struct {
+	Content string    `json:"content" es:"type=text"`
+	At      time.Time `json:"at"`
+}
+
+type mappingBase struct {
+	Title     string    `json:"title" es:"type=keyword"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type mappingNode struct {
+	Name     string        `json:"name"`
+	Children []mappingNode `json:"children"`
+}
+
+type mappingPointerNode struct {
+	Parent *mappingPointerNode `json:"parent"`
+}
+
+// mappingDocument 与 GenerateMapping 文档中的示例一致
+type mappingDocument struct {
+	ID        string           `json:"id" es:"id,type=keyword"`
+	Title     string           `json:"title" es:"analyzer=ik_max_word,search_analyzer=ik_smart"`
+	Content   string           `json:"content" es:"type=text,index=false"`
+	Tags      []string         `json:"tags" es:"type=keyword"`
+	Author    mappingAuthor    `json:"author"`
+	Comments  []mappingComment `json:"comments"`
+	CreatedAt time.Time        `json:"created_at"`
+	Internal  string           `json:"internal" es:"-"`
+}
+
+const keywordSubfield = `"fields":{"keyword":{"ignore_above":256,"type":"keyword"}}`
+
+func TestGenerateMapping(t *testing.T) {
+	tests := []struct {
+		name     string
+		document interface{}
+		want     string
+	}{
+		{
+			name: "string defaults to text with keyword subfield",
+			document: struct {
+				Name string `json:"name"`
+			}{},
+			want: `{"properties":{"name":{` + keywordSubfield + `,"type":"text"}}}`,
+		},
+		{
+			name: "explicit text",
+			document: struct {
+				Plain   string `json:"plain" es:"type=text"`
+				Keyword string `json:"keyword" es:"type=text,keyword"`
+				Custom  string `json:"custom" es:"keyword,fields=none"`
+			}{},
+			want: `{"properties":{` +
+				`"custom":{"fields":"none","type":"text"},` +
+				`"keyword":{` + keywordSubfield + `,"type":"text"},` +
+				`"plain":{"type":"text"}}}`,
+		},
+		{
+			name: "inferred types",
+			document: &struct {
+				Flag    bool              `json:"flag"`
+				Small   int8              `json:"small"`
+				Short   int16             `json:"short"`
+				Int     int32             `json:"int"`
+				Long    *int64            `json:"long"`
+				Float   float32           `json:"float"`
+				Double  float64           `json:"double"`
+				Data    []byte            `json:"data"`
+				At      time.Time         `json:"at"`
+				AtPtr   *time.Time        `json:"at_ptr"`
+				Labels  map[string]string `json:"labels"`
+				Numbers []int             `json:"numbers"`
+				Any     interface{}       `json:"any"`
+			}{},
+			want: `{"properties":{` +
+				`"at":{"type":"date"},` +
+				`"at_ptr":{"type":"date"},` +
+				`"data":{"type":"binary"},` +
+				`"double":{"type":"double"},` +
+				`"flag":{"type":"boolean"},` +
+				`"float":{"type":"float"},` +
+				`"int":{"type":"integer"},` +
+				`"labels":{"type":"object"},` +
+				`"long":{"type":"long"},` +
+				`"numbers":{"type":"long"},` +
+				`"short":{"type":"short"},` +
+				`"small":{"type":"byte"}}}`,
+		},
+		{
+			name: "object and nested",
+			document: struct {
+				Author   mappingAuthor     `json:"author"`
+				Owner    *mappingAuthor    `json:"owner" es:"type=object"`
+				Comments []*mappingComment `json:"comments"`
+				Flat     []mappingAuthor   `json:"flat" es:"type=object"`
+			}{},
+			want: `{"properties":{` +
+				`"author":{"properties":{"name":{"type":"keyword"}}},` +
+				`"comments":{"properties":{"at":{"type":"date"},"content":{"type":"text"}},"type":"nested"},` +
+				`"flat":{"properties":{"name":{"type":"keyword"}},"type":"object"},` +
+				`"owner":{"properties":{"name":{"type":"keyword"}},"type":"object"}}}`,
+		},
+		{
+			name: "skipped fields",
+			document: struct {
+				Kept     int    `json:"kept"`
+				ESSkip   string `json:"es_skip" es:"-"`
+				JSONSkip string `json:"-"`
+				private  string
+				NoTag    bool
+			}{},
+			want: `{"properties":{"NoTag":{"type":"boolean"},"kept":{"type":"long"}}}`,
+		},
+		{
+			name: "embedded struct is flattened",
+			document: struct {
+				mappingBase
+				Title string        `json:"title" es:"type=text"`
+				Named mappingAuthor `json:"named"`
+			}{},
+			want: `{"properties":{` +
+				`"created_at":{"type":"date"},` +
+				`"named":{"properties":{"name":{"type":"keyword"}}},` +
+				`"title":{"type":"text"}}}`,
+		},
+		{
+			name: "option values",
+			document: struct {
+				Code   string  `json:"code" es:"type=keyword,index=false,doc_values=true,ignore_above=100"`
+				Price  float64 `json:"price" es:"type=scaled_float,scaling_factor=100"`
+				Weight float32 `json:"weight" es:"boost=1.5,null_value=abc"`
+			}{},
+			want: `{"properties":{` +
+				`"code":{"doc_values":true,"ignore_above":100,"index":false,"type":"keyword"},` +
+				`"price":{"scaling_factor":100,"type":"scaled_float"},` +
+				`"weight":{"boost":1.5,"null_value":"abc","type":"float"}}}`,
+		},
+		{
+			// id 是文档元信息的 flag，不会出现在 mapping 中
+			name:     "doc example",
+			document: mappingDocument{},
+			want: `{"properties":{` +
+				`"author":{"properties":{"name":{"type":"keyword"}}},` +
+				`"comments":{"properties":{"at":{"type":"date"},"content":{"type":"text"}},"type":"nested"},` +
+				`"content":{"index":false,"type":"text"},` +
+				`"created_at":{"type":"date"},` +
+				`"id":{"type":"keyword"},` +
+				`"tags":{"type":"keyword"},` +
+				`"title":{"analyzer":"ik_max_word",` + keywordSubfield + `,"search_analyzer":"ik_smart","type":"text"}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping, err := GenerateMapping(tt.document)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(mapping)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateMappingError(t *testing.T) {
+	tests := []struct {
+		name     string
+		document interface{}
+	}{
+		{name: "nil", document: nil},
+		{name: "not a struct", document: map[string]string{}},
+		{name: "recursive slice", document: mappingNode{}},
+		{name: "recursive pointer", document: &mappingPointerNode{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := GenerateMapping(tt.document); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
@@ -5,194 +5,293 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
-	"strings"
+	"io/ioutil"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
 // PerformESQuery es response body will be unmarshal to `response`, so `response` parameter must be a pointer
 func PerformESQuery(request interface{}, response interface{}, index string, client *elasticsearch.Client) error {
-	var err error
+	return PerformESQueryContext(context.Background(), request, response, index, client)
+}
 
-	var reqBody bytes.Buffer
-	err = json.NewEncoder(&reqBody).Encode(request)
+// PerformESQueryContext 同 PerformESQuery，ctx 取消时中止请求，ctx 有 deadline 时同时作为 es 的查询超时时间
+func PerformESQueryContext(ctx context.Context, request interface{}, response interface{}, index string, client *elasticsearch.Client) error {
+	reqBody, err := json.Marshal(request)
 	if err != nil {
 		err = fmt.Errorf("encode query failed, %v", err)
 		return err
 	}
-	res, err := client.Search(
-		client.Search.WithContext(context.Background()),
-		client.Search.WithIndex(string(index)),
-		client.Search.WithBody(&reqBody),
-		client.Search.WithTrackTotalHits(true),
-		client.Search.WithPretty(),
-		client.Search.WithTimeout(10*time.Second),
-	)
+	start := time.Now()
+	res, err := performWithRetry(ctx, func() (*esapi.Response, error) {
+		return client.Search(
+			client.Search.WithContext(ctx),
+			client.Search.WithIndex(string(index)),
+			client.Search.WithBody(bytes.NewReader(reqBody)),
+			client.Search.WithTrackTotalHits(true),
+			client.Search.WithPretty(),
+			client.Search.WithTimeout(searchTimeout(ctx, 10*time.Second)),
+		)
+	})
 	if err != nil {
-		err = fmt.Errorf("Error getting response: %s", err)
+		logRequest("Search", index, start, reqBody, nil, err)
 		return err
 	}
 	defer res.Body.Close()
 
-	if res.IsError() {
-		var e map[string]interface{}
-		if err = json.NewDecoder(res.Body).Decode(&e); err != nil {
-			err = fmt.Errorf("Error parsing the response body: %s", err)
-		} else {
-			err = fmt.Errorf("[%s] %s: %s", res.Status(),
-				e["error"].(map[string]interface{})["type"],
-				e["error"].(map[string]interface{})["reason"])
-		}
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		err = fmt.Errorf("read response failed, %v", err)
+		logRequest("Search", index, start, reqBody, nil, err)
 		return err
 	}
-
-	var builder strings.Builder
-	buf := make([]byte, 256)
-	for {
-		n, err := res.Body.Read(buf)
-		builder.Write(buf[:n])
-		if err != nil {
-			if err != io.EOF {
-				err = fmt.Errorf("read response failed, %v", err)
-				return err
-			}
-			break
+	var fields logrus.Fields
+	if currentLogger().IsLevelEnabled(logrus.DebugLevel) {
+		// response 的类型由调用方决定，只在需要记录日志时单独解析命中数
+		var r scrollResponse
+		if json.Unmarshal(resBody, &r) == nil {
+			fields = logrus.Fields{"hits": len(r.Hits.Hits)}
 		}
 	}
-	return json.Unmarshal([]byte(builder.String()), response)
+	logRequest("Search", index, start, reqBody, fields, nil)
+	return json.Unmarshal(resBody, response)
 }
 
 // PerformESQueryAndBuildScroll ...
 func PerformESQueryAndBuildScroll(query map[string]interface{}, index string, esClient *elasticsearch.Client) ([]map[string]interface{}, string, error) {
+	return PerformESQueryAndBuildScrollContext(context.Background(), query, index, esClient)
+}
 
-	startTime := time.Now()
-
+// PerformESQueryAndBuildScrollContext 同 PerformESQueryAndBuildScroll，ctx 取消时中止请求，ctx 有 deadline 时同时作为 es 的查询超时时间
+func PerformESQueryAndBuildScrollContext(ctx context.Context, query map[string]interface{}, index string, esClient *elasticsearch.Client) ([]map[string]interface{}, string, error) {
 	resultList := make([]map[string]interface{}, 0)
-	var err error
 
-	var reqBody bytes.Buffer
-	err = json.NewEncoder(&reqBody).Encode(query)
+	reqBody, err := json.Marshal(query)
 	if err != nil {
 		err = fmt.Errorf("encode query failed, %v", err)
 		return resultList, "", errors.WithStack(err)
 	}
-	res, err := esClient.Search(
-		esClient.Search.WithContext(context.Background()),
-		esClient.Search.WithIndex(string(index)),
-		esClient.Search.WithBody(&reqBody),
-		esClient.Search.WithTrackTotalHits(true),
-		esClient.Search.WithPretty(),
-		esClient.Search.WithTimeout(5*60*time.Second),
-		esClient.Search.WithScroll(time.Minute),
-	)
+	start := time.Now()
+	res, err := performWithRetry(ctx, func() (*esapi.Response, error) {
+		return esClient.Search(
+			esClient.Search.WithContext(ctx),
+			esClient.Search.WithIndex(string(index)),
+			esClient.Search.WithBody(bytes.NewReader(reqBody)),
+			esClient.Search.WithTrackTotalHits(true),
+			esClient.Search.WithPretty(),
+			esClient.Search.WithTimeout(searchTimeout(ctx, 5*60*time.Second)),
+			esClient.Search.WithScroll(time.Minute),
+		)
+	})
 	if err != nil {
-		err = fmt.Errorf("Error getting response: %s", err)
-		return resultList, "", errors.WithStack(err)
+		logRequest("Search", index, start, reqBody, nil, err)
+		return resultList, "", err
 	}
 	defer res.Body.Close()
 
-	if res.IsError() {
-		var e map[string]interface{}
-		if err = json.NewDecoder(res.Body).Decode(&e); err != nil {
-			err = fmt.Errorf("Error parsing the response body: %s", err)
-		} else {
-			err = fmt.Errorf("[%s] %s: %s", res.Status(),
-				e["error"].(map[string]interface{})["type"],
-				e["error"].(map[string]interface{})["reason"])
-		}
-		return resultList, "", errors.WithStack(err)
-	}
-
 	result := make(map[string]interface{})
 	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
 		err = fmt.Errorf("Error parsing the response body: %s", err)
+		logRequest("Search", index, start, reqBody, nil, err)
 		return resultList, "", errors.WithStack(err)
 	}
 	resultList = append(resultList, result)
 
-	hits := result["hits"].(map[string]interface{})["hits"].([]interface{})
+	hits := resultHits(result)
+	logRequest("Search", index, start, reqBody, logrus.Fields{"hits": len(hits)}, nil)
 
 	scrollID := ""
-	if len(hits) == query["size"].(int) {
-		scrollID = result["_scroll_id"].(string)
-	}
-
-	// test code
-	if len(hits) > 0 {
-		log.Println("")
-		log.Println("---------------- First level of PerformESQueryAndBuildScroll ---------------")
-		length := len(hits)
-		if length > 1 {
-			length = 1
-		}
-		resultJSON, _ := json.Marshal(hits[0:length])
-		log.Printf("adam Build scroll, result[%+v]", string(resultJSON))
-		log.Printf("adam Build scroll, len(hits): [%+v], query time[%+v]", len(hits), time.Now().Sub(startTime))
+	// query 中没有 size 时无法判断是否还有下一页，只要有结果就返回 scrollID
+	if size, ok := querySize(query); len(hits) > 0 && (!ok || len(hits) == size) {
+		scrollID, _ = result["_scroll_id"].(string)
 	}
 
-	return resultList, scrollID, err
+	return resultList, scrollID, nil
 }
 
 // PerformESQueryWithScroll ...
 func PerformESQueryWithScroll(scrollID string, esClient *elasticsearch.Client) ([]map[string]interface{}, string, error) {
+	return PerformESQueryWithScrollContext(context.Background(), scrollID, esClient)
+}
+
+// PerformESQueryWithScrollContext 同 PerformESQueryWithScroll，ctx 取消或超时时中止请求
+func PerformESQueryWithScrollContext(ctx context.Context, scrollID string, esClient *elasticsearch.Client) ([]map[string]interface{}, string, error) {
 
 	if scrollID == "" {
-		return nil, "", fmt.Errorf("=========================*************scrollID can not be empty in adam.PerformESQueryWithScroll")
+		return nil, "", errors.New("scrollID can not be empty")
 	}
 
 	resultList := make([]map[string]interface{}, 0)
-	startTime := time.Now()
-
-	res, err := esClient.Scroll(
-		esClient.Scroll.WithContext(context.Background()),
-		esClient.Scroll.WithPretty(),
-		esClient.Scroll.WithScrollID(scrollID),
-		esClient.Scroll.WithScroll(time.Minute),
-	)
+	start := time.Now()
+
+	res, err := performWithRetry(ctx, func() (*esapi.Response, error) {
+		return esClient.Scroll(
+			esClient.Scroll.WithContext(ctx),
+			esClient.Scroll.WithPretty(),
+			esClient.Scroll.WithScrollID(scrollID),
+			esClient.Scroll.WithScroll(time.Minute),
+		)
+	})
 	if err != nil {
-		err = fmt.Errorf("Error getting response: %s", err)
-		return resultList, "", errors.WithStack(err)
+		logRequest("Scroll", "", start, nil, nil, err)
+		return resultList, "", err
 	}
 	defer res.Body.Close()
 
-	if res.IsError() {
-		var e map[string]interface{}
-		if err = json.NewDecoder(res.Body).Decode(&e); err != nil {
-			err = fmt.Errorf("Error parsing the response body: %s", err)
-		} else {
-			err = fmt.Errorf("[%s] %s: %s", res.Status(),
-				e["error"].(map[string]interface{})["type"],
-				e["error"].(map[string]interface{})["reason"])
-		}
-		return resultList, "", errors.WithStack(err)
-	}
-
 	result := make(map[string]interface{})
 	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
 		err = fmt.Errorf("Error parsing the response body: %s", err)
+		logRequest("Scroll", "", start, nil, nil, err)
 		return resultList, "", errors.WithStack(err)
 	}
 
 	resultList = append(resultList, result)
 
 	scrollID = ""
-	hits := result["hits"].(map[string]interface{})["hits"].([]interface{})
+	hits := resultHits(result)
 	if len(hits) > 0 {
-		scrollID = result["_scroll_id"].(string)
+		scrollID, _ = result["_scroll_id"].(string)
 	}
+	logRequest("Scroll", "", start, nil, logrus.Fields{"hits": len(hits)}, nil)
 
-	// test code
-	if len(hits) > 0 {
-		log.Println("---------------- Second level of PerformESQueryWithScroll ---------------")
-		resultJSON, _ := json.Marshal(hits[0])
-		log.Printf("adam PerformESQueryWithScroll， len(resultList)[%d], len(hits)[%d], query time: [%+v]",
-			len(resultList), len(hits), time.Now().Sub(startTime))
-		log.Printf("adam PerformESQueryWithScroll， hits[0]: [%+v]", string(resultJSON))
+	return resultList, scrollID, nil
+}
+
+// MultiSearchRequest _msearch 中的一个查询
+type MultiSearchRequest struct {
+	Index string
+	// Body 查询语句，可以是 SearchSource、map 等会被 json 序列化的结构，也可以是 JSON 字符串
+	Body interface{}
+	// Response 该查询的结果会被解析到 Response，必须是指针，为空时不解析
+	Response interface{}
+}
+
+// MultiSearch 在一个 _msearch 请求中执行多个查询，每个查询的结果解析到各自的 Response
+// 返回的 errs 与 requests 一一对应，单个查询失败时对应位置为 *Error，其他查询不受影响；
+// err 不为空表示整个请求失败
+//
+//	var list, counter es.SearchResponse
+//	errs, err := es.MultiSearch(ctx, []es.MultiSearchRequest{
+//		{Index: "products", Body: listQuery, Response: &list},
+//		{Index: "products", Body: counterQuery, Response: &counter},
+//	}, esClient)
+func MultiSearch(ctx context.Context, requests []MultiSearchRequest, esClient *elasticsearch.Client) (errs []error, err error) {
+	if len(requests) == 0 {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	for i, request := range requests {
+		header, err := json.Marshal(map[string]interface{}{"index": request.Index})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		body, err := encodeBody(request.Body)
+		if err != nil {
+			return nil, err
+		}
+		if body == nil {
+			body = []byte("{}")
+		}
+		buf.Write(header)
+		buf.WriteByte('\n')
+		// 每个查询必须在一行内
+		if err := json.Compact(&buf, body); err != nil {
+			return nil, errors.Wrapf(err, "invalid body of request %d", i)
+		}
+		buf.WriteByte('\n')
 	}
+	reqBody := buf.Bytes()
 
-	return resultList, scrollID, nil
+	var r struct {
+		Responses []json.RawMessage `json:"responses"`
+	}
+	err = doRequest(ctx, esClient, &r, func() esapi.Request {
+		return esapi.MsearchRequest{
+			Body: bytes.NewReader(reqBody),
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(r.Responses) != len(requests) {
+		return nil, errors.Errorf("expected %d responses, got %d", len(requests), len(r.Responses))
+	}
+
+	errs = make([]error, len(requests))
+	for i, raw := range r.Responses {
+		var status struct {
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(raw, &status); err != nil {
+			errs[i] = errors.Wrap(err, "decode response failed")
+			continue
+		}
+		if len(status.Error) > 0 {
+			errs[i] = errorFromBody(status.Status, raw)
+			continue
+		}
+		if requests[i].Response == nil {
+			continue
+		}
+		if err := json.Unmarshal(raw, requests[i].Response); err != nil {
+			errs[i] = errors.Wrap(err, "decode response failed")
+		}
+	}
+	return errs, nil
+}
+
+// Count 返回 index 中满足 query 的文档数，不返回文档
+// query 为查询语句中的 query 部分，可以是 Query 或 map，为空时统计所有文档
+func Count(ctx context.Context, index string, query interface{}, esClient *elasticsearch.Client) (int64, error) {
+	var body interface{}
+	switch q := query.(type) {
+	case nil:
+	case Query:
+		// 值为 nil 的指针（如 var q *BoolQuery）与 nil 一样统计所有文档
+		if !isNilQuery(q) {
+			body = map[string]interface{}{"query": q.Map()}
+		}
+	case map[string]interface{}:
+		if q != nil {
+			body = map[string]interface{}{"query": q}
+		}
+	default:
+		body = map[string]interface{}{"query": q}
+	}
+	reqBody, err := encodeBody(body)
+	if err != nil {
+		return 0, err
+	}
+	var r struct {
+		Count int64 `json:"count"`
+	}
+	err = doRequest(ctx, esClient, &r, func() esapi.Request {
+		return esapi.CountRequest{
+			Index: []string{index},
+			Body:  bodyReader(reqBody),
+		}
+	})
+	return r.Count, err
+}
+
+// resultHits 读取查询结果中的 hits.hits，结构不符时返回空
+func resultHits(result map[string]interface{}) []interface{} {
+	outer, _ := result["hits"].(map[string]interface{})
+	hits, _ := outer["hits"].([]interface{})
+	return hits
+}
+
+// searchTimeout ctx 有 deadline 时返回剩余时间，否则返回默认的查询超时时间
+func searchTimeout(ctx context.Context, defaultTimeout time.Duration) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining > 0 && remaining < defaultTimeout {
+			return remaining
+		}
+	}
+	return defaultTimeout
 }
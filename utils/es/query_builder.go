@@ -0,0 +1,763 @@
+package es
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// Query 查询条件，Map 返回的内容即 es 查询语句中对应的部分
+//
+//	query := es.NewBoolQuery().
+//		Filter(es.NewTermQuery("status", "active")).
+//		MustNot(es.NewExistsQuery("deleted_at")).
+//		Should(es.NewMatchQuery("title", keyword), es.NewMatchPhraseQuery("content", keyword)).
+//		MinimumShouldMatch(1)
+//	source := es.NewSearchSource().Query(query).Sort("created_at", false).From(0).Size(20)
+//	err := es.PerformESQuery(source, &response, index, esClient)
+type Query interface {
+	Map() map[string]interface{}
+}
+
+// RawQuery 直接使用手写的查询语句，用于 builder 暂不支持的查询类型
+type RawQuery map[string]interface{}
+
+// Map ...
+func (q RawQuery) Map() map[string]interface{} {
+	return q
+}
+
+// MatchAllQuery match_all 查询
+type MatchAllQuery struct{}
+
+// NewMatchAllQuery ...
+func NewMatchAllQuery() *MatchAllQuery {
+	return &MatchAllQuery{}
+}
+
+// Map ...
+func (q *MatchAllQuery) Map() map[string]interface{} {
+	return map[string]interface{}{"match_all": map[string]interface{}{}}
+}
+
+// BoolQuery bool 组合查询
+type BoolQuery struct {
+	must               []Query
+	filter             []Query
+	should             []Query
+	mustNot            []Query
+	minimumShouldMatch interface{}
+	boost              *float64
+}
+
+// NewBoolQuery ...
+func NewBoolQuery() *BoolQuery {
+	return &BoolQuery{}
+}
+
+// Must 必须满足的条件，参与算分
+func (q *BoolQuery) Must(queries ...Query) *BoolQuery {
+	q.must = append(q.must, queries...)
+	return q
+}
+
+// Filter 必须满足的条件，不参与算分
+func (q *BoolQuery) Filter(queries ...Query) *BoolQuery {
+	q.filter = append(q.filter, queries...)
+	return q
+}
+
+// Should 应该满足的条件
+func (q *BoolQuery) Should(queries ...Query) *BoolQuery {
+	q.should = append(q.should, queries...)
+	return q
+}
+
+// MustNot 必须不满足的条件
+func (q *BoolQuery) MustNot(queries ...Query) *BoolQuery {
+	q.mustNot = append(q.mustNot, queries...)
+	return q
+}
+
+// MinimumShouldMatch should 条件最少满足的个数，可以是数字或 "75%" 这样的字符串
+func (q *BoolQuery) MinimumShouldMatch(minimum interface{}) *BoolQuery {
+	q.minimumShouldMatch = minimum
+	return q
+}
+
+// Boost ...
+func (q *BoolQuery) Boost(boost float64) *BoolQuery {
+	q.boost = &boost
+	return q
+}
+
+// Map ...
+func (q *BoolQuery) Map() map[string]interface{} {
+	body := map[string]interface{}{}
+	if len(q.must) > 0 {
+		body["must"] = queryMaps(q.must)
+	}
+	if len(q.filter) > 0 {
+		body["filter"] = queryMaps(q.filter)
+	}
+	if len(q.should) > 0 {
+		body["should"] = queryMaps(q.should)
+	}
+	if len(q.mustNot) > 0 {
+		body["must_not"] = queryMaps(q.mustNot)
+	}
+	if q.minimumShouldMatch != nil {
+		body["minimum_should_match"] = q.minimumShouldMatch
+	}
+	if q.boost != nil {
+		body["boost"] = *q.boost
+	}
+	return map[string]interface{}{"bool": body}
+}
+
+// TermQuery term 精确查询
+type TermQuery struct {
+	field string
+	value interface{}
+}
+
+// NewTermQuery ...
+func NewTermQuery(field string, value interface{}) *TermQuery {
+	return &TermQuery{field: field, value: value}
+}
+
+// Map ...
+func (q *TermQuery) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"term": map[string]interface{}{q.field: q.value},
+	}
+}
+
+// TermsQuery terms 精确查询，匹配任意一个值即可
+type TermsQuery struct {
+	field  string
+	values []interface{}
+}
+
+// NewTermsQuery ...
+func NewTermsQuery(field string, values ...interface{}) *TermsQuery {
+	return &TermsQuery{field: field, values: values}
+}
+
+// NewTermsQueryFromStrings 使用字符串列表创建 terms 查询
+func NewTermsQueryFromStrings(field string, values ...string) *TermsQuery {
+	q := &TermsQuery{field: field, values: make([]interface{}, 0, len(values))}
+	for _, v := range values {
+		q.values = append(q.values, v)
+	}
+	return q
+}
+
+// Map ...
+func (q *TermsQuery) Map() map[string]interface{} {
+	values := q.values
+	if values == nil {
+		values = []interface{}{}
+	}
+	return map[string]interface{}{
+		"terms": map[string]interface{}{q.field: values},
+	}
+}
+
+// MatchQuery match 全文检索
+type MatchQuery struct {
+	field              string
+	text               interface{}
+	operator           string
+	analyzer           string
+	fuzziness          string
+	minimumShouldMatch interface{}
+	boost              *float64
+}
+
+// NewMatchQuery ...
+func NewMatchQuery(field string, text interface{}) *MatchQuery {
+	return &MatchQuery{field: field, text: text}
+}
+
+// Operator and 或 or，默认 or
+func (q *MatchQuery) Operator(operator string) *MatchQuery {
+	q.operator = operator
+	return q
+}
+
+// Analyzer ...
+func (q *MatchQuery) Analyzer(analyzer string) *MatchQuery {
+	q.analyzer = analyzer
+	return q
+}
+
+// Fuzziness 如 AUTO、1、2
+func (q *MatchQuery) Fuzziness(fuzziness string) *MatchQuery {
+	q.fuzziness = fuzziness
+	return q
+}
+
+// MinimumShouldMatch ...
+func (q *MatchQuery) MinimumShouldMatch(minimum interface{}) *MatchQuery {
+	q.minimumShouldMatch = minimum
+	return q
+}
+
+// Boost ...
+func (q *MatchQuery) Boost(boost float64) *MatchQuery {
+	q.boost = &boost
+	return q
+}
+
+// Map ...
+func (q *MatchQuery) Map() map[string]interface{} {
+	body := map[string]interface{}{"query": q.text}
+	if q.operator != "" {
+		body["operator"] = q.operator
+	}
+	if q.analyzer != "" {
+		body["analyzer"] = q.analyzer
+	}
+	if q.fuzziness != "" {
+		body["fuzziness"] = q.fuzziness
+	}
+	if q.minimumShouldMatch != nil {
+		body["minimum_should_match"] = q.minimumShouldMatch
+	}
+	if q.boost != nil {
+		body["boost"] = *q.boost
+	}
+	return map[string]interface{}{
+		"match": map[string]interface{}{q.field: body},
+	}
+}
+
+// MatchPhraseQuery match_phrase 短语查询
+type MatchPhraseQuery struct {
+	field    string
+	text     interface{}
+	slop     *int
+	analyzer string
+}
+
+// NewMatchPhraseQuery ...
+func NewMatchPhraseQuery(field string, text interface{}) *MatchPhraseQuery {
+	return &MatchPhraseQuery{field: field, text: text}
+}
+
+// Slop 允许词之间间隔的最大距离
+func (q *MatchPhraseQuery) Slop(slop int) *MatchPhraseQuery {
+	q.slop = &slop
+	return q
+}
+
+// Analyzer ...
+func (q *MatchPhraseQuery) Analyzer(analyzer string) *MatchPhraseQuery {
+	q.analyzer = analyzer
+	return q
+}
+
+// Map ...
+func (q *MatchPhraseQuery) Map() map[string]interface{} {
+	body := map[string]interface{}{"query": q.text}
+	if q.slop != nil {
+		body["slop"] = *q.slop
+	}
+	if q.analyzer != "" {
+		body["analyzer"] = q.analyzer
+	}
+	return map[string]interface{}{
+		"match_phrase": map[string]interface{}{q.field: body},
+	}
+}
+
+// RangeQuery range 范围查询
+type RangeQuery struct {
+	field    string
+	gt       interface{}
+	gte      interface{}
+	lt       interface{}
+	lte      interface{}
+	format   string
+	timeZone string
+}
+
+// NewRangeQuery ...
+func NewRangeQuery(field string) *RangeQuery {
+	return &RangeQuery{field: field}
+}
+
+// Gt 大于
+func (q *RangeQuery) Gt(value interface{}) *RangeQuery {
+	q.gt = value
+	return q
+}
+
+// Gte 大于等于
+func (q *RangeQuery) Gte(value interface{}) *RangeQuery {
+	q.gte = value
+	return q
+}
+
+// Lt 小于
+func (q *RangeQuery) Lt(value interface{}) *RangeQuery {
+	q.lt = value
+	return q
+}
+
+// Lte 小于等于
+func (q *RangeQuery) Lte(value interface{}) *RangeQuery {
+	q.lte = value
+	return q
+}
+
+// Format 日期格式，如 yyyy-MM-dd
+func (q *RangeQuery) Format(format string) *RangeQuery {
+	q.format = format
+	return q
+}
+
+// TimeZone 如 +08:00
+func (q *RangeQuery) TimeZone(timeZone string) *RangeQuery {
+	q.timeZone = timeZone
+	return q
+}
+
+// Map ...
+func (q *RangeQuery) Map() map[string]interface{} {
+	body := map[string]interface{}{}
+	if q.gt != nil {
+		body["gt"] = q.gt
+	}
+	if q.gte != nil {
+		body["gte"] = q.gte
+	}
+	if q.lt != nil {
+		body["lt"] = q.lt
+	}
+	if q.lte != nil {
+		body["lte"] = q.lte
+	}
+	if q.format != "" {
+		body["format"] = q.format
+	}
+	if q.timeZone != "" {
+		body["time_zone"] = q.timeZone
+	}
+	return map[string]interface{}{
+		"range": map[string]interface{}{q.field: body},
+	}
+}
+
+// ExistsQuery exists 查询，字段存在且不为 null
+type ExistsQuery struct {
+	field string
+}
+
+// NewExistsQuery ...
+func NewExistsQuery(field string) *ExistsQuery {
+	return &ExistsQuery{field: field}
+}
+
+// Map ...
+func (q *ExistsQuery) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"exists": map[string]interface{}{"field": q.field},
+	}
+}
+
+// PrefixQuery prefix 前缀查询
+type PrefixQuery struct {
+	field  string
+	prefix string
+}
+
+// NewPrefixQuery ...
+func NewPrefixQuery(field string, prefix string) *PrefixQuery {
+	return &PrefixQuery{field: field, prefix: prefix}
+}
+
+// Map ...
+func (q *PrefixQuery) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"prefix": map[string]interface{}{
+			q.field: map[string]interface{}{"value": q.prefix},
+		},
+	}
+}
+
+// WildcardQuery wildcard 通配符查询，支持 * 和 ?
+type WildcardQuery struct {
+	field   string
+	pattern string
+}
+
+// NewWildcardQuery ...
+func NewWildcardQuery(field string, pattern string) *WildcardQuery {
+	return &WildcardQuery{field: field, pattern: pattern}
+}
+
+// Map ...
+func (q *WildcardQuery) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"wildcard": map[string]interface{}{
+			q.field: map[string]interface{}{"value": q.pattern},
+		},
+	}
+}
+
+// NestedQuery nested 查询，用于 nested 类型的字段
+type NestedQuery struct {
+	path      string
+	query     Query
+	scoreMode string
+}
+
+// NewNestedQuery query 为 nil 时匹配所有嵌套文档
+func NewNestedQuery(path string, query Query) *NestedQuery {
+	return &NestedQuery{path: path, query: query}
+}
+
+// ScoreMode avg、max、min、sum、none
+func (q *NestedQuery) ScoreMode(scoreMode string) *NestedQuery {
+	q.scoreMode = scoreMode
+	return q
+}
+
+// Map ...
+func (q *NestedQuery) Map() map[string]interface{} {
+	body := map[string]interface{}{
+		"path":  q.path,
+		"query": queryMap(q.query),
+	}
+	if q.scoreMode != "" {
+		body["score_mode"] = q.scoreMode
+	}
+	return map[string]interface{}{"nested": body}
+}
+
+// ScoreFunction function_score 中的打分函数
+type ScoreFunction interface {
+	Map() map[string]interface{}
+}
+
+// WeightFunction 满足 filter 时乘以固定权重
+type WeightFunction struct {
+	filter Query
+	weight float64
+}
+
+// NewWeightFunction ...
+func NewWeightFunction(weight float64) *WeightFunction {
+	return &WeightFunction{weight: weight}
+}
+
+// Filter 只对满足条件的文档生效
+func (f *WeightFunction) Filter(filter Query) *WeightFunction {
+	f.filter = filter
+	return f
+}
+
+// Map ...
+func (f *WeightFunction) Map() map[string]interface{} {
+	body := map[string]interface{}{"weight": f.weight}
+	if !isNilQuery(f.filter) {
+		body["filter"] = f.filter.Map()
+	}
+	return body
+}
+
+// FieldValueFactorFunction 使用字段的值参与打分
+type FieldValueFactorFunction struct {
+	filter   Query
+	field    string
+	factor   *float64
+	modifier string
+	missing  *float64
+}
+
+// NewFieldValueFactorFunction ...
+func NewFieldValueFactorFunction(field string) *FieldValueFactorFunction {
+	return &FieldValueFactorFunction{field: field}
+}
+
+// Filter 只对满足条件的文档生效
+func (f *FieldValueFactorFunction) Filter(filter Query) *FieldValueFactorFunction {
+	f.filter = filter
+	return f
+}
+
+// Factor ...
+func (f *FieldValueFactorFunction) Factor(factor float64) *FieldValueFactorFunction {
+	f.factor = &factor
+	return f
+}
+
+// Modifier 如 log1p、sqrt
+func (f *FieldValueFactorFunction) Modifier(modifier string) *FieldValueFactorFunction {
+	f.modifier = modifier
+	return f
+}
+
+// Missing 字段不存在时使用的值
+func (f *FieldValueFactorFunction) Missing(missing float64) *FieldValueFactorFunction {
+	f.missing = &missing
+	return f
+}
+
+// Map ...
+func (f *FieldValueFactorFunction) Map() map[string]interface{} {
+	factor := map[string]interface{}{"field": f.field}
+	if f.factor != nil {
+		factor["factor"] = *f.factor
+	}
+	if f.modifier != "" {
+		factor["modifier"] = f.modifier
+	}
+	if f.missing != nil {
+		factor["missing"] = *f.missing
+	}
+	body := map[string]interface{}{"field_value_factor": factor}
+	if !isNilQuery(f.filter) {
+		body["filter"] = f.filter.Map()
+	}
+	return body
+}
+
+// FunctionScoreQuery function_score 自定义打分查询
+type FunctionScoreQuery struct {
+	query     Query
+	functions []ScoreFunction
+	scoreMode string
+	boostMode string
+	maxBoost  *float64
+	minScore  *float64
+}
+
+// NewFunctionScoreQuery ...
+func NewFunctionScoreQuery(query Query) *FunctionScoreQuery {
+	return &FunctionScoreQuery{query: query}
+}
+
+// Add 添加打分函数
+func (q *FunctionScoreQuery) Add(functions ...ScoreFunction) *FunctionScoreQuery {
+	q.functions = append(q.functions, functions...)
+	return q
+}
+
+// ScoreMode 多个打分函数的合并方式，multiply、sum、avg、first、max、min
+func (q *FunctionScoreQuery) ScoreMode(scoreMode string) *FunctionScoreQuery {
+	q.scoreMode = scoreMode
+	return q
+}
+
+// BoostMode 函数得分与查询得分的合并方式，multiply、replace、sum、avg、max、min
+func (q *FunctionScoreQuery) BoostMode(boostMode string) *FunctionScoreQuery {
+	q.boostMode = boostMode
+	return q
+}
+
+// MaxBoost ...
+func (q *FunctionScoreQuery) MaxBoost(maxBoost float64) *FunctionScoreQuery {
+	q.maxBoost = &maxBoost
+	return q
+}
+
+// MinScore ...
+func (q *FunctionScoreQuery) MinScore(minScore float64) *FunctionScoreQuery {
+	q.minScore = &minScore
+	return q
+}
+
+// Map ...
+func (q *FunctionScoreQuery) Map() map[string]interface{} {
+	body := map[string]interface{}{}
+	if !isNilQuery(q.query) {
+		body["query"] = q.query.Map()
+	}
+	if len(q.functions) > 0 {
+		functions := make([]interface{}, 0, len(q.functions))
+		for _, f := range q.functions {
+			functions = append(functions, f.Map())
+		}
+		body["functions"] = functions
+	}
+	if q.scoreMode != "" {
+		body["score_mode"] = q.scoreMode
+	}
+	if q.boostMode != "" {
+		body["boost_mode"] = q.boostMode
+	}
+	if q.maxBoost != nil {
+		body["max_boost"] = *q.maxBoost
+	}
+	if q.minScore != nil {
+		body["min_score"] = *q.minScore
+	}
+	return map[string]interface{}{"function_score": body}
+}
+
+// SearchSource 完整的查询请求体，可以直接作为 PerformESQuery 的 request，
+// 也可以通过 Map 得到 PerformESQueryAndBuildScroll、NewIterator 使用的 query
+type SearchSource struct {
+	query          Query
+	sorts          []interface{}
+	includes       []string
+	excludes       []string
+	disableSource  bool
+	from           *int
+	size           *int
+	trackTotalHits interface{}
+	aggregations   map[string]Aggregation
+}
+
+// NewSearchSource ...
+func NewSearchSource() *SearchSource {
+	return &SearchSource{}
+}
+
+// Query ...
+func (s *SearchSource) Query(query Query) *SearchSource {
+	s.query = query
+	return s
+}
+
+// Sort 按字段排序，ascending 为 false 时倒序
+func (s *SearchSource) Sort(field string, ascending bool) *SearchSource {
+	order := "asc"
+	if !ascending {
+		order = "desc"
+	}
+	s.sorts = append(s.sorts, map[string]interface{}{
+		field: map[string]interface{}{"order": order},
+	})
+	return s
+}
+
+// SortBy 使用完整的排序语句，如 {"price": {"order": "asc", "missing": "_last"}}
+func (s *SearchSource) SortBy(sorts ...map[string]interface{}) *SearchSource {
+	for _, sort := range sorts {
+		s.sorts = append(s.sorts, sort)
+	}
+	return s
+}
+
+// FetchSource 只返回 _source 中的这些字段
+func (s *SearchSource) FetchSource(includes ...string) *SearchSource {
+	s.includes = append(s.includes, includes...)
+	return s
+}
+
+// ExcludeSource 不返回 _source 中的这些字段
+func (s *SearchSource) ExcludeSource(excludes ...string) *SearchSource {
+	s.excludes = append(s.excludes, excludes...)
+	return s
+}
+
+// DisableSource 不返回 _source
+func (s *SearchSource) DisableSource() *SearchSource {
+	s.disableSource = true
+	return s
+}
+
+// From ...
+func (s *SearchSource) From(from int) *SearchSource {
+	s.from = &from
+	return s
+}
+
+// Size ...
+func (s *SearchSource) Size(size int) *SearchSource {
+	s.size = &size
+	return s
+}
+
+// TrackTotalHits true、false 或者精确计数的上限
+func (s *SearchSource) TrackTotalHits(trackTotalHits interface{}) *SearchSource {
+	s.trackTotalHits = trackTotalHits
+	return s
+}
+
+// Aggregation 添加聚合，见 Aggregation
+func (s *SearchSource) Aggregation(name string, agg Aggregation) *SearchSource {
+	if s.aggregations == nil {
+		s.aggregations = make(map[string]Aggregation)
+	}
+	s.aggregations[name] = agg
+	return s
+}
+
+// Map ...
+func (s *SearchSource) Map() map[string]interface{} {
+	body := map[string]interface{}{}
+	if !isNilQuery(s.query) {
+		body["query"] = s.query.Map()
+	}
+	if len(s.sorts) > 0 {
+		body["sort"] = s.sorts
+	}
+	switch {
+	case s.disableSource:
+		body["_source"] = false
+	case len(s.includes) > 0 || len(s.excludes) > 0:
+		source := map[string]interface{}{}
+		if len(s.includes) > 0 {
+			source["includes"] = s.includes
+		}
+		if len(s.excludes) > 0 {
+			source["excludes"] = s.excludes
+		}
+		body["_source"] = source
+	}
+	if s.from != nil {
+		body["from"] = *s.from
+	}
+	if s.size != nil {
+		body["size"] = *s.size
+	}
+	if s.trackTotalHits != nil {
+		body["track_total_hits"] = s.trackTotalHits
+	}
+	if len(s.aggregations) > 0 {
+		aggs := make(map[string]interface{}, len(s.aggregations))
+		for name, agg := range s.aggregations {
+			aggs[name] = agg.Map()
+		}
+		body["aggs"] = aggs
+	}
+	return body
+}
+
+// MarshalJSON ...
+func (s *SearchSource) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Map())
+}
+
+func queryMaps(queries []Query) []interface{} {
+	maps := make([]interface{}, 0, len(queries))
+	for _, q := range queries {
+		maps = append(maps, queryMap(q))
+	}
+	return maps
+}
+
+// queryMap query 为 nil 时返回 match_all，避免 Map 时 panic
+func queryMap(query Query) map[string]interface{} {
+	if isNilQuery(query) {
+		return NewMatchAllQuery().Map()
+	}
+	return query.Map()
+}
+
+// isNilQuery query 为 nil 或者是值为 nil 的指针，如 var q *BoolQuery
+func isNilQuery(query Query) bool {
+	if query == nil {
+		return true
+	}
+	v := reflect.ValueOf(query)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func:
+		return v.IsNil()
+	}
+	return false
+}
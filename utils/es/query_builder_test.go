@@ -0,0 +1,203 @@
+package es
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type mapper interface {
+	Map() map[string]interface{}
+}
+
+func TestQueryBuilderJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		query mapper
+		want  string
+	}{
+		{
+			name:  "match_all",
+			query: NewMatchAllQuery(),
+			want:  `{"match_all":{}}`,
+		},
+		{
+			name:  "term",
+			query: NewTermQuery("status", "active"),
+			want:  `{"term":{"status":"active"}}`,
+		},
+		{
+			name:  "terms",
+			query: NewTermsQueryFromStrings("tags", "a", "b"),
+			want:  `{"terms":{"tags":["a","b"]}}`,
+		},
+		{
+			name:  "terms without values",
+			query: NewTermsQuery("tags"),
+			want:  `{"terms":{"tags":[]}}`,
+		},
+		{
+			name:  "match",
+			query: NewMatchQuery("title", "quick fox"),
+			want:  `{"match":{"title":{"query":"quick fox"}}}`,
+		},
+		{
+			name:  "match with options",
+			query: NewMatchQuery("title", "quick fox").Operator("and").Fuzziness("AUTO").MinimumShouldMatch("75%").Boost(2),
+			want:  `{"match":{"title":{"boost":2,"fuzziness":"AUTO","minimum_should_match":"75%","operator":"and","query":"quick fox"}}}`,
+		},
+		{
+			name:  "match_phrase",
+			query: NewMatchPhraseQuery("content", "quick fox").Slop(1),
+			want:  `{"match_phrase":{"content":{"query":"quick fox","slop":1}}}`,
+		},
+		{
+			name:  "range",
+			query: NewRangeQuery("created_at").Gte("2021-01-01").Lt("2022-01-01").Format("yyyy-MM-dd").TimeZone("+08:00"),
+			want:  `{"range":{"created_at":{"format":"yyyy-MM-dd","gte":"2021-01-01","lt":"2022-01-01","time_zone":"+08:00"}}}`,
+		},
+		{
+			name:  "range gt lte",
+			query: NewRangeQuery("price").Gt(10).Lte(20.5),
+			want:  `{"range":{"price":{"gt":10,"lte":20.5}}}`,
+		},
+		{
+			name: "bool",
+			query: NewBoolQuery().
+				Must(NewMatchQuery("title", "fox")).
+				Filter(NewTermQuery("status", "active")).
+				Should(NewPrefixQuery("name", "jo"), NewWildcardQuery("name", "j*n")).
+				MustNot(NewExistsQuery("deleted_at")).
+				MinimumShouldMatch(1).
+				Boost(1.5),
+			want: `{"bool":{"boost":1.5,` +
+				`"filter":[{"term":{"status":"active"}}],` +
+				`"minimum_should_match":1,` +
+				`"must":[{"match":{"title":{"query":"fox"}}}],` +
+				`"must_not":[{"exists":{"field":"deleted_at"}}],` +
+				`"should":[{"prefix":{"name":{"value":"jo"}}},{"wildcard":{"name":{"value":"j*n"}}}]}}`,
+		},
+		{
+			name:  "empty bool",
+			query: NewBoolQuery(),
+			want:  `{"bool":{}}`,
+		},
+		{
+			name:  "nested",
+			query: NewNestedQuery("comments", NewTermQuery("comments.author", "tom")).ScoreMode("max"),
+			want:  `{"nested":{"path":"comments","query":{"term":{"comments.author":"tom"}},"score_mode":"max"}}`,
+		},
+		{
+			name:  "nested without query",
+			query: NewNestedQuery("comments", nil),
+			want:  `{"nested":{"path":"comments","query":{"match_all":{}}}}`,
+		},
+		{
+			name: "function_score",
+			query: NewFunctionScoreQuery(NewMatchQuery("title", "fox")).
+				Add(
+					NewWeightFunction(2).Filter(NewTermQuery("featured", true)),
+					NewFieldValueFactorFunction("likes").Factor(1.2).Modifier("log1p").Missing(1),
+				).
+				ScoreMode("sum").
+				BoostMode("multiply").
+				MaxBoost(10).
+				MinScore(0.5),
+			want: `{"function_score":{"boost_mode":"multiply",` +
+				`"functions":[{"filter":{"term":{"featured":true}},"weight":2},` +
+				`{"field_value_factor":{"factor":1.2,"field":"likes","missing":1,"modifier":"log1p"}}],` +
+				`"max_boost":10,"min_score":0.5,` +
+				`"query":{"match":{"title":{"query":"fox"}}},"score_mode":"sum"}}`,
+		},
+		{
+			name:  "filter aggregation without filter",
+			query: NewFilterAggregation(nil),
+			want:  `{"filter":{"match_all":{}}}`,
+		},
+		{
+			// 值为 nil 的指针与 nil 一样处理，不会 panic
+			name: "typed nil queries",
+			query: NewBoolQuery().
+				Must((*TermQuery)(nil)).
+				Filter(NewNestedQuery("comments", (*BoolQuery)(nil))),
+			want: `{"bool":{"filter":[{"nested":{"path":"comments","query":{"match_all":{}}}}],"must":[{"match_all":{}}]}}`,
+		},
+		{
+			name: "typed nil function score",
+			query: NewFunctionScoreQuery((*MatchQuery)(nil)).
+				Add(NewWeightFunction(2).Filter((*TermQuery)(nil))),
+			want: `{"function_score":{"functions":[{"weight":2}]}}`,
+		},
+		{
+			name:  "typed nil filter aggregation",
+			query: NewFilterAggregation((*BoolQuery)(nil)),
+			want:  `{"filter":{"match_all":{}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.query.Map())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchSourceJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		source *SearchSource
+		want   string
+	}{
+		{
+			name:   "empty",
+			source: NewSearchSource(),
+			want:   `{}`,
+		},
+		{
+			name:   "typed nil query",
+			source: NewSearchSource().Query((*BoolQuery)(nil)),
+			want:   `{}`,
+		},
+		{
+			name: "full",
+			source: NewSearchSource().
+				Query(NewBoolQuery().Filter(NewTermQuery("status", "active"))).
+				Sort("created_at", false).
+				SortBy(map[string]interface{}{"price": map[string]interface{}{"order": "asc", "missing": "_last"}}).
+				FetchSource("id", "title").
+				ExcludeSource("content").
+				From(20).
+				Size(10).
+				TrackTotalHits(true),
+			want: `{"_source":{"excludes":["content"],"includes":["id","title"]},` +
+				`"from":20,` +
+				`"query":{"bool":{"filter":[{"term":{"status":"active"}}]}},` +
+				`"size":10,` +
+				`"sort":[{"created_at":{"order":"desc"}},{"price":{"missing":"_last","order":"asc"}}],` +
+				`"track_total_hits":true}`,
+		},
+		{
+			name:   "disable source",
+			source: NewSearchSource().FetchSource("id").DisableSource().Size(0),
+			want:   `{"_source":false,"size":0}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// MarshalJSON 与 Map 的结果应当一致
+			got, err := json.Marshal(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package es
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCount(t *testing.T) {
+	server, esClient := newTestServer(t)
+	defer server.Close()
+
+	if err := PerformESInsert("products", testProducts(10), esClient); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		query interface{}
+		want  int64
+	}{
+		{name: "all", query: nil, want: 10},
+		{name: "typed nil query", query: (*BoolQuery)(nil), want: 10},
+		{name: "nil map", query: map[string]interface{}(nil), want: 10},
+		{name: "query", query: NewRangeQuery("price").Lte(3), want: 3},
+		{name: "map", query: map[string]interface{}{"term": map[string]interface{}{"id": "5"}}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, err := Count(ctx, "products", tt.query, esClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != tt.want {
+				t.Fatalf("expected %d, got %d", tt.want, count)
+			}
+		})
+	}
+
+	if _, err := Count(ctx, "missing", nil, esClient); !IsIndexMissing(err) {
+		t.Fatalf("expected index missing error, got %v", err)
+	}
+}
+
+func TestMultiSearch(t *testing.T) {
+	server, esClient := newTestServer(t)
+	defer server.Close()
+
+	if err := PerformESInsert("products", testProducts(10), esClient); err != nil {
+		t.Fatal(err)
+	}
+
+	var cheap, one SearchResponse
+	errs, err := MultiSearch(context.Background(), []MultiSearchRequest{
+		{Index: "products", Body: NewSearchSource().Query(NewRangeQuery("price").Lt(4)).Size(2), Response: &cheap},
+		{Index: "products", Body: `{"query": {"ids": {"values": ["7"]}}}`, Response: &one},
+		{Index: "missing", Body: NewSearchSource()},
+	}, esClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	if !IsIndexMissing(errs[2]) {
+		t.Fatalf("expected index missing error, got %v", errs[2])
+	}
+
+	if cheap.TotalHits() != 3 || len(cheap.Hits.Hits) != 2 {
+		t.Fatalf("expected 3 total and 2 hits, got %d and %d", cheap.TotalHits(), len(cheap.Hits.Hits))
+	}
+	var products []testProduct
+	if err := one.DecodeHits(&products); err != nil {
+		t.Fatal(err)
+	}
+	if len(products) != 1 || products[0].ID != "7" {
+		t.Fatalf("unexpected products %+v", products)
+	}
+}
@@ -0,0 +1,321 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/pkg/errors"
+)
+
+// ReindexOptions Reindex 配置
+type ReindexOptions struct {
+	// Alias 复制完成后切换到新索引的别名，默认为 src
+	Alias string
+	// ReplaceIndex Alias 是一个索引而不是别名时（如第一次迁移时 src 就是索引本身），
+	// 在切换别名的同一个 _aliases 请求中用 remove_index 删除该索引；为 false 时在复制前返回错误
+	ReplaceIndex bool
+	// Definition 新索引的 settings、mappings，见 CreateIndex
+	Definition interface{}
+	// Version 新索引的版本号，默认为当前时间，如 20210102150405
+	Version string
+	// Query 只复制满足条件的文档，为查询语句中的 query 部分
+	Query map[string]interface{}
+	// Transform 不为空时在客户端通过 scroll + bulk 复制，返回新文档；ok 为 false 时跳过该文档
+	// 为空时使用服务端的 _reindex
+	Transform func(hit Hit) (document interface{}, ok bool, err error)
+	// Slices 服务端 _reindex 的并发切片数，0 表示不切片
+	Slices int
+	// RequestsPerSecond 服务端 _reindex 的限流，0 表示不限流
+	RequestsPerSecond int
+	// PollInterval 查询服务端任务进度的间隔，默认 5s
+	PollInterval time.Duration
+	// OnProgress 复制进度回调
+	OnProgress func(status TaskStatus)
+	// SkipCountValidation 不校验新旧索引的文档数是否一致
+	// 校验时直接比较复制完成后 src 与新索引的文档数，src 在复制期间仍有写入时会校验失败，此时应跳过校验
+	SkipCountValidation bool
+	// DeleteOld 切换别名后删除别名之前指向的索引
+	DeleteOld bool
+}
+
+// ReindexResult Reindex 结果
+type ReindexResult struct {
+	Index           string   // 新索引
+	PreviousIndices []string // 别名之前指向的索引
+	SourceCount     int64
+	DestCount       int64
+	Skipped         int64 // Transform 跳过的文档数
+	DeletedOld      bool  // ReplaceIndex 时同名的索引总是被删除
+}
+
+// Reindex 零停机重建索引：
+// 按 dstTemplate 创建带版本号的新索引，复制 src 中的文档，校验文档数，
+// 原子地将别名切换到新索引，并按需删除旧索引。
+// dstTemplate 中的 %s 会被替换为版本号，没有 %s 时在末尾追加 _版本号。
+// 切换别名前出错时会删除新建的索引。
+// 别名不能与索引同名，Alias 是一个已有的索引时需要设置 ReplaceIndex，否则在复制前返回错误。
+//
+//	result, err := es.Reindex(ctx, "products", "products_%s", es.ReindexOptions{
+//		Definition:   es.IndexDefinition{Mappings: mappings},
+//		ReplaceIndex: true, // 第一次迁移时 products 是索引
+//		DeleteOld:    true,
+//	}, esClient)
+func Reindex(ctx context.Context, src string, dstTemplate string, opts ReindexOptions, esClient *elasticsearch.Client) (*ReindexResult, error) {
+	alias := opts.Alias
+	if alias == "" {
+		alias = src
+	}
+	version := opts.Version
+	if version == "" {
+		version = time.Now().Format("20060102150405")
+	}
+	dst := dstTemplate + "_" + version
+	if strings.Contains(dstTemplate, "%s") {
+		dst = fmt.Sprintf(dstTemplate, version)
+	}
+
+	aliasIsIndex, err := isConcreteIndex(ctx, alias, esClient)
+	if err != nil {
+		return nil, err
+	}
+	if aliasIsIndex && !opts.ReplaceIndex {
+		return nil, errors.Errorf("%s is an index, an alias with the same name can not be created, set ReplaceIndex to replace it", alias)
+	}
+
+	result := &ReindexResult{Index: dst}
+	if err := CreateIndex(ctx, dst, opts.Definition, esClient); err != nil {
+		return nil, errors.Wrapf(err, "create index %s failed", dst)
+	}
+
+	if err := copyDocuments(ctx, src, dst, opts, result, esClient); err != nil {
+		// 新索引还没有被使用，清理掉
+		_ = DeleteESIndexContext(context.Background(), dst, esClient)
+		return nil, err
+	}
+
+	if aliasIsIndex {
+		// 添加别名与删除同名索引必须在同一个请求中，否则两者都无法单独完成
+		err = UpdateAliases(ctx, esClient,
+			AliasAction{Action: "add", Index: dst, Alias: alias},
+			AliasAction{Action: "remove_index", Index: alias},
+		)
+		if err != nil {
+			_ = DeleteESIndexContext(context.Background(), dst, esClient)
+			return nil, errors.Wrapf(err, "replace index %s with alias to %s failed", alias, dst)
+		}
+		result.PreviousIndices = []string{alias}
+		result.DeletedOld = true
+		return result, nil
+	}
+
+	previous, err := SwapAlias(ctx, alias, dst, esClient)
+	if err != nil {
+		_ = DeleteESIndexContext(context.Background(), dst, esClient)
+		return nil, errors.Wrapf(err, "swap alias %s to %s failed", alias, dst)
+	}
+	result.PreviousIndices = previous
+
+	if opts.DeleteOld {
+		for _, index := range previous {
+			if err := DeleteESIndexContext(ctx, index, esClient); err != nil {
+				return result, errors.Wrapf(err, "delete old index %s failed", index)
+			}
+		}
+		result.DeletedOld = true
+	}
+	return result, nil
+}
+
+// copyDocuments 复制文档并校验文档数
+func copyDocuments(ctx context.Context, src string, dst string, opts ReindexOptions, result *ReindexResult, esClient *elasticsearch.Client) error {
+	var err error
+	if opts.Transform != nil {
+		err = clientSideReindex(ctx, src, dst, opts, result, esClient)
+	} else {
+		err = serverSideReindex(ctx, src, dst, opts, esClient)
+	}
+	if err != nil {
+		return err
+	}
+	if opts.SkipCountValidation {
+		return nil
+	}
+
+	if err := refreshIndex(ctx, dst, esClient); err != nil {
+		return err
+	}
+	if result.SourceCount, err = Count(ctx, src, opts.Query, esClient); err != nil {
+		return err
+	}
+	if result.DestCount, err = Count(ctx, dst, nil, esClient); err != nil {
+		return err
+	}
+	if expected := result.SourceCount - result.Skipped; result.DestCount != expected {
+		return errors.Errorf("document count mismatch, expected %d in %s, got %d", expected, dst, result.DestCount)
+	}
+	return nil
+}
+
+func serverSideReindex(ctx context.Context, src string, dst string, opts ReindexOptions, esClient *elasticsearch.Client) error {
+	source := map[string]interface{}{"index": src}
+	if opts.Query != nil {
+		source["query"] = opts.Query
+	}
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"source": source,
+		"dest":   map[string]interface{}{"index": dst},
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	waitForCompletion := false
+	taskID, err := startTask(ctx, esClient, func() esapi.Request {
+		req := esapi.ReindexRequest{
+			Body:              bytes.NewReader(reqBody),
+			WaitForCompletion: &waitForCompletion,
+		}
+		if opts.Slices > 0 {
+			req.Slices = opts.Slices
+		}
+		if opts.RequestsPerSecond > 0 {
+			req.RequestsPerSecond = &opts.RequestsPerSecond
+		}
+		return req
+	})
+	if err != nil {
+		return errors.Wrap(err, "start reindex failed")
+	}
+
+	_, err = WaitForTask(ctx, taskID, opts.PollInterval, progressCallback(opts.OnProgress), esClient)
+	if err != nil && ctx.Err() != nil {
+		// ctx 取消后任务仍在服务端运行，取消并等待任务结束，调用方才能安全地删除 dst
+		waitForCompletion := true
+		_ = doRequest(context.Background(), esClient, nil, func() esapi.Request {
+			return esapi.TasksCancelRequest{TaskID: taskID, WaitForCompletion: &waitForCompletion}
+		})
+	}
+	return err
+}
+
+func clientSideReindex(ctx context.Context, src string, dst string, opts ReindexOptions, result *ReindexResult, esClient *elasticsearch.Client) error {
+	query := map[string]interface{}{}
+	if opts.Query != nil {
+		query["query"] = opts.Query
+	}
+	it := NewIterator(query, src, esClient)
+	defer it.Close()
+
+	var (
+		mu       sync.Mutex
+		created  int64
+		failed   int64
+		firstErr error
+	)
+	// Created 在文档写入成功后才计数
+	onSuccess := func(BulkIndexerItem, BulkItemResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		created++
+	}
+	onFailure := func(item BulkIndexerItem, itemResult BulkItemResult, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed++
+		if firstErr == nil {
+			if err == nil {
+				err = &BulkError{Items: []BulkItemResult{itemResult}}
+			}
+			firstErr = err
+		}
+	}
+
+	bi, err := NewBulkIndexer(BulkIndexerConfig{Index: dst}, esClient)
+	if err != nil {
+		return err
+	}
+	// 出错或 ctx 取消时不再发送新的 bulk，并在返回前等待正在发送的 bulk 完成，
+	// 否则调用方删除 dst 后，这些 bulk 会以动态 mapping 重新创建 dst
+	defer func() {
+		bi.stop()
+		bi.drain()
+	}()
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			bi.stop()
+		case <-finished:
+		}
+	}()
+
+	var status TaskStatus
+	for it.Next(ctx) {
+		hit := it.RawHit()
+		document, ok, err := opts.Transform(hit)
+		if err != nil {
+			return errors.Wrapf(err, "transform document %s failed", hit.ID)
+		}
+		status.Total++
+		if !ok {
+			result.Skipped++
+			status.Noops++
+			continue
+		}
+		err = bi.Add(ctx, BulkIndexerItem{
+			Action:     "index",
+			DocumentID: hit.ID,
+			Body:       document,
+			OnSuccess:  onSuccess,
+			OnFailure:  onFailure,
+		})
+		if err != nil {
+			return err
+		}
+		if opts.OnProgress != nil && status.Total%int64(defaultPageSize) == 0 {
+			mu.Lock()
+			status.Created = created
+			mu.Unlock()
+			opts.OnProgress(status)
+		}
+	}
+	bi.drain()
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := it.Err(); err != nil {
+		return err
+	}
+
+	status.Created = created
+	if opts.OnProgress != nil {
+		opts.OnProgress(status)
+	}
+	if failed > 0 {
+		return errors.Wrapf(firstErr, "%d documents failed to index", failed)
+	}
+	return nil
+}
+
+// isConcreteIndex name 是否为一个已存在的索引（而不是别名）
+func isConcreteIndex(ctx context.Context, name string, esClient *elasticsearch.Client) (bool, error) {
+	indices, err := GetAliasIndices(ctx, name, esClient)
+	if err != nil || len(indices) > 0 {
+		return false, err
+	}
+	return IndexExists(ctx, name, esClient)
+}
+
+func refreshIndex(ctx context.Context, index string, esClient *elasticsearch.Client) error {
+	return doRequest(ctx, esClient, nil, func() esapi.Request {
+		return esapi.IndicesRefreshRequest{Index: []string{index}}
+	})
+}
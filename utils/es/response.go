@@ -0,0 +1,105 @@
+package es
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// SearchResponse _search 的响应，可以作为 PerformESQuery、MultiSearch 的 response
+//
+//	var res es.SearchResponse
+//	if err := es.PerformESQuery(query, &res, index, esClient); err != nil {
+//		return err
+//	}
+//	var products []Product
+//	if err := res.DecodeHits(&products); err != nil {
+//		return err
+//	}
+type SearchResponse struct {
+	Took         int64        `json:"took"`
+	TimedOut     bool         `json:"timed_out"`
+	Shards       ShardsInfo   `json:"_shards"`
+	Hits         SearchHits   `json:"hits"`
+	Aggregations Aggregations `json:"aggregations,omitempty"`
+	ScrollID     string       `json:"_scroll_id,omitempty"`
+	PitID        string       `json:"pit_id,omitempty"`
+}
+
+// ShardsInfo 查询涉及的分片
+type ShardsInfo struct {
+	Total      int            `json:"total"`
+	Successful int            `json:"successful"`
+	Skipped    int            `json:"skipped"`
+	Failed     int            `json:"failed"`
+	Failures   []ShardFailure `json:"failures,omitempty"`
+}
+
+// SearchHits 命中的文档
+type SearchHits struct {
+	// Total track_total_hits 为 false 时为空
+	Total    *TotalHits `json:"total,omitempty"`
+	MaxScore *float64   `json:"max_score"`
+	Hits     []Hit      `json:"hits"`
+}
+
+// TotalHits 命中的文档数，Relation 为 gte 时 Value 只是下限
+type TotalHits struct {
+	Value    int64  `json:"value"`
+	Relation string `json:"relation"`
+}
+
+// UnmarshalJSON 兼容 es 6 及 rest_total_hits_as_int 时 total 为数字的格式
+func (t *TotalHits) UnmarshalJSON(data []byte) error {
+	var value int64
+	if err := json.Unmarshal(data, &value); err == nil {
+		t.Value = value
+		t.Relation = "eq"
+		return nil
+	}
+	type totalHits TotalHits
+	return json.Unmarshal(data, (*totalHits)(t))
+}
+
+// TotalHits 命中的文档数，没有返回 total 时为 0
+func (r *SearchResponse) TotalHits() int64 {
+	if r.Hits.Total == nil {
+		return 0
+	}
+	return r.Hits.Total.Value
+}
+
+// DecodeHits 将每个 hit 的 _source 解析到 into 中，into 必须是切片的指针，元素可以是结构体或结构体指针
+//
+//	var products []*Product
+//	err := res.DecodeHits(&products)
+func (r *SearchResponse) DecodeHits(into interface{}) error {
+	v := reflect.ValueOf(into)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		return errors.Errorf("into must be a pointer to slice, got %T", into)
+	}
+	slice := v.Elem()
+	elemType := slice.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+
+	result := reflect.MakeSlice(slice.Type(), 0, len(r.Hits.Hits))
+	for _, hit := range r.Hits.Hits {
+		elem := reflect.New(elemType)
+		if len(hit.Source) > 0 {
+			if err := json.Unmarshal(hit.Source, elem.Interface()); err != nil {
+				return errors.Wrapf(err, "decode hit %s failed", hit.ID)
+			}
+		}
+		if isPtr {
+			result = reflect.Append(result, elem)
+		} else {
+			result = reflect.Append(result, elem.Elem())
+		}
+	}
+	slice.Set(result)
+	return nil
+}
@@ -0,0 +1,209 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy 集群繁忙（429、503 等）时的重试策略
+// bulk 请求只重试状态码可重试的失败文档，不会重发整个请求体
+type RetryPolicy struct {
+	MaxAttempts     int           // 最多尝试次数，包含第一次请求，小于等于 1 时不重试
+	BaseDelay       time.Duration // 第一次重试前的等待时间，之后每次翻倍
+	MaxDelay        time.Duration // 等待时间上限
+	Jitter          float64       // 等待时间的随机抖动比例，取值 0 ~ 1
+	RetryableStatus []int         // 需要重试的 http 状态码
+}
+
+// DefaultRetryPolicy 默认重试策略
+// 客户端的 transport 默认已经对 502、503、504 重试 MaxRetries（3）次，见 client.InitElasticsearch，
+// 因此默认只重试 transport 不处理的 429；在 RetryableStatus 中加入 502、503、504 时，
+// 单个请求最多会被发送 MaxAttempts × (1 + MaxRetries) 次，此时应在客户端配置中设置 DisableRetry
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:     3,
+	BaseDelay:       200 * time.Millisecond,
+	MaxDelay:        10 * time.Second,
+	Jitter:          0.2,
+	RetryableStatus: []int{429},
+}
+
+var (
+	retryPolicyMu sync.RWMutex
+	retryPolicy   = DefaultRetryPolicy
+)
+
+// SetRetryPolicy 设置 es 查询、scroll 以及 bulk 请求使用的重试策略，可以与请求并发调用
+func SetRetryPolicy(policy RetryPolicy) {
+	policy.RetryableStatus = append([]int(nil), policy.RetryableStatus...)
+	retryPolicyMu.Lock()
+	defer retryPolicyMu.Unlock()
+	retryPolicy = policy
+}
+
+// currentRetryPolicy 返回当前的重试策略，每个请求开始时读取一次
+func currentRetryPolicy() RetryPolicy {
+	retryPolicyMu.RLock()
+	defer retryPolicyMu.RUnlock()
+	return retryPolicy
+}
+
+func (p RetryPolicy) retryable(status int) bool {
+	for _, s := range p.RetryableStatus {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff 第 attempt 次请求失败后的等待时间，attempt 从 1 开始
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(delay))
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return delay
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// performWithRetry 执行 do 发起的请求，响应状态码可重试时按重试策略重试
+// 成功时返回的响应体由调用方关闭，失败时返回解析后的错误
+func performWithRetry(ctx context.Context, do func() (*esapi.Response, error)) (*esapi.Response, error) {
+	policy := currentRetryPolicy()
+	for attempt := 1; ; attempt++ {
+		res, err := do()
+		if err != nil {
+			return nil, errors.Wrap(err, "Error getting response")
+		}
+		if !res.IsError() {
+			return res, nil
+		}
+		if attempt >= policy.MaxAttempts || !policy.retryable(res.StatusCode) {
+			defer res.Body.Close()
+			return nil, responseError(res)
+		}
+		res.Body.Close()
+		if err := sleepContext(ctx, policy.backoff(attempt)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// performBulkWithRetry 发送 bulk 请求，items 为每个文档对应的 NDJSON 内容
+// 状态码可重试的失败文档会单独组成新的请求重试，返回结果中的 Position 对应 items 的下标
+func performBulkWithRetry(ctx context.Context, index string, items [][]byte, esClient *elasticsearch.Client) (*BulkResult, error) {
+	policy := currentRetryPolicy()
+	result := &BulkResult{Items: make([]BulkItemResult, len(items))}
+	if len(items) == 0 {
+		return result, nil
+	}
+	// 响应中缺少的文档按失败处理，而不是作为零值被当成成功
+	for i := range result.Items {
+		result.Items[i] = BulkItemResult{Position: i, Error: &BulkItemError{
+			Type:   "missing_item_result",
+			Reason: "no result for the document in the bulk response",
+		}}
+	}
+
+	pending := make([]int, len(items))
+	for i := range pending {
+		pending[i] = i
+	}
+	for attempt := 1; ; attempt++ {
+		var body bytes.Buffer
+		for _, pos := range pending {
+			body.Write(items[pos])
+		}
+		r, err := performBulk(ctx, index, body.Bytes(), esClient)
+		if err != nil {
+			return nil, err
+		}
+		result.Took += r.Took
+
+		var retryItems []int
+		for i, item := range r.Items {
+			if i >= len(pending) {
+				break
+			}
+			item.Position = pending[i]
+			result.Items[item.Position] = item
+			if item.Failed() && policy.retryable(item.Status) {
+				retryItems = append(retryItems, item.Position)
+			}
+		}
+		if len(retryItems) == 0 || attempt >= policy.MaxAttempts {
+			break
+		}
+		pending = retryItems
+		if err := sleepContext(ctx, policy.backoff(attempt)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, item := range result.Items {
+		if item.Failed() {
+			result.Errors = true
+			break
+		}
+	}
+	return result, nil
+}
+
+// splitBulkBody 将 NDJSON 格式的 bulk 请求体按文档拆分，每个文档包含操作行以及文档行（delete 没有文档行）
+func splitBulkBody(body []byte) ([][]byte, error) {
+	var items [][]byte
+	lines := bytes.SplitAfter(body, []byte("\n"))
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var header map[string]json.RawMessage
+		if err := json.Unmarshal(line, &header); err != nil {
+			return nil, errors.Wrapf(err, "invalid bulk action line %d", i+1)
+		}
+		item := append([]byte{}, line...)
+		if len(item) > 0 && item[len(item)-1] != '\n' {
+			item = append(item, '\n')
+		}
+		if _, ok := header["delete"]; !ok {
+			if i+1 >= len(lines) || len(bytes.TrimSpace(lines[i+1])) == 0 {
+				return nil, errors.Errorf("bulk action line %d has no source", i+1)
+			}
+			i++
+			item = append(item, lines[i]...)
+			if item[len(item)-1] != '\n' {
+				item = append(item, '\n')
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
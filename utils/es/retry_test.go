@@ -0,0 +1,216 @@
+package es
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: 100 * time.Millisecond},
+		{attempt: 2, want: 200 * time.Millisecond},
+		{attempt: 3, want: 400 * time.Millisecond},
+		{attempt: 4, want: 800 * time.Millisecond},
+		{attempt: 5, want: time.Second},
+		{attempt: 100, want: time.Second},
+	}
+	for _, tt := range tests {
+		if got := policy.backoff(tt.attempt); got != tt.want {
+			t.Errorf("attempt %d: expected %v, got %v", tt.attempt, tt.want, got)
+		}
+	}
+
+	policy.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		if got := policy.backoff(2); got < 160*time.Millisecond || got > 240*time.Millisecond {
+			t.Fatalf("backoff %v out of jitter range", got)
+		}
+	}
+}
+
+func TestSplitBulkBody(t *testing.T) {
+	body := `{"index":{"_id":"1"}}
+{"name":"a"}
+
+{"delete":{"_id":"2"}}
+{"create":{"_id":"3"}}
+{"name":"c"}`
+	items, err := splitBulkBody([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"{\"index\":{\"_id\":\"1\"}}\n{\"name\":\"a\"}\n",
+		"{\"delete\":{\"_id\":\"2\"}}\n",
+		"{\"create\":{\"_id\":\"3\"}}\n{\"name\":\"c\"}\n",
+	}
+	got := make([]string, len(items))
+	for i, item := range items {
+		got[i] = string(item)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	for _, invalid := range []string{"not json\n", "{\"index\":{}}\n"} {
+		if _, err := splitBulkBody([]byte(invalid)); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+// bulkServer 按顺序返回 responses 中的响应，并记录每个 bulk 请求体
+type bulkServer struct {
+	mu        sync.Mutex
+	responses []func(body string) (int, string)
+	bodies    []string
+}
+
+func (s *bulkServer) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	n := len(s.bodies)
+	s.bodies = append(s.bodies, string(body))
+	s.mu.Unlock()
+
+	status, response := http.StatusInternalServerError, `{"error":"unexpected request"}`
+	if n < len(s.responses) {
+		status, response = s.responses[n](string(body))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprint(w, response)
+}
+
+func bulkItemJSON(id string, status int) string {
+	if status >= 300 {
+		return fmt.Sprintf(`{"index":{"_index":"products","_id":%q,"status":%d,"error":{"type":"es_rejected_execution_exception","reason":"rejected"}}}`, id, status)
+	}
+	return fmt.Sprintf(`{"index":{"_index":"products","_id":%q,"status":%d,"result":"created"}}`, id, status)
+}
+
+func bulkItems(ids ...string) [][]byte {
+	items := make([][]byte, len(ids))
+	for i, id := range ids {
+		items[i] = []byte(fmt.Sprintf("{\"index\":{\"_id\":%q}}\n{\"name\":%q}\n", id, id))
+	}
+	return items
+}
+
+func TestPerformBulkWithRetry(t *testing.T) {
+	SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, RetryableStatus: []int{429}})
+	defer SetRetryPolicy(DefaultRetryPolicy)
+
+	bs := &bulkServer{responses: []func(string) (int, string){
+		// 整个请求被拒绝
+		func(string) (int, string) {
+			return http.StatusTooManyRequests, `{"error":{"type":"es_rejected_execution_exception","reason":"rejected"},"status":429}`
+		},
+		// 部分文档被拒绝，409 不可重试
+		func(string) (int, string) {
+			return http.StatusOK, `{"took":1,"errors":true,"items":[` +
+				bulkItemJSON("1", 201) + `,` + bulkItemJSON("2", 429) + `,` + bulkItemJSON("3", 409) + `,` + bulkItemJSON("4", 201) + `]}`
+		},
+		func(string) (int, string) {
+			return http.StatusOK, `{"took":1,"errors":false,"items":[` + bulkItemJSON("2", 201) + `]}`
+		},
+	}}
+	server, esClient := newTestClient(t, bs.handle)
+	defer server.Close()
+
+	result, err := performBulkWithRetry(context.Background(), "products", bulkItems("1", "2", "3", "4"), esClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bs.bodies) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(bs.bodies))
+	}
+	// 只重发状态码可重试的文档
+	if want := string(bulkItems("2")[0]); bs.bodies[2] != want {
+		t.Fatalf("expected retry body %q, got %q", want, bs.bodies[2])
+	}
+
+	var statuses []int
+	for i, item := range result.Items {
+		if item.Position != i {
+			t.Fatalf("item %d has position %d", i, item.Position)
+		}
+		statuses = append(statuses, item.Status)
+	}
+	if !reflect.DeepEqual(statuses, []int{201, 201, 409, 201}) {
+		t.Fatalf("unexpected statuses %v", statuses)
+	}
+	if failed := result.Failed(); !result.Errors || len(failed) != 1 || failed[0].ID != "3" {
+		t.Fatalf("unexpected failures %+v", failed)
+	}
+}
+
+func TestPerformBulkWithRetryMissingItems(t *testing.T) {
+	bs := &bulkServer{responses: []func(string) (int, string){
+		func(string) (int, string) {
+			return http.StatusOK, `{"took":1,"errors":false,"items":[` + bulkItemJSON("1", 201) + `]}`
+		},
+	}}
+	server, esClient := newTestClient(t, bs.handle)
+	defer server.Close()
+
+	result, err := performBulkWithRetry(context.Background(), "products", bulkItems("1", "2"), esClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := result.Failed()
+	if !result.Errors || len(failed) != 1 || failed[0].Position != 1 {
+		t.Fatalf("expected the missing item to fail, got %+v", result.Items)
+	}
+}
+
+func TestSetRetryPolicy(t *testing.T) {
+	status := []int{429}
+	SetRetryPolicy(RetryPolicy{MaxAttempts: 1, RetryableStatus: status})
+	defer SetRetryPolicy(DefaultRetryPolicy)
+	// SetRetryPolicy 保存的是副本
+	status[0] = 500
+	if policy := currentRetryPolicy(); !policy.retryable(429) || policy.retryable(500) {
+		t.Fatalf("unexpected retryable status %v", policy.RetryableStatus)
+	}
+
+	bs := &bulkServer{responses: []func(string) (int, string){
+		func(string) (int, string) {
+			return http.StatusTooManyRequests, `{"error":{"type":"es_rejected_execution_exception","reason":"rejected"},"status":429}`
+		},
+		func(string) (int, string) {
+			return http.StatusOK, `{"took":1,"errors":false,"items":[` + bulkItemJSON("1", 201) + `]}`
+		},
+	}}
+	server, esClient := newTestClient(t, bs.handle)
+	defer server.Close()
+
+	// MaxAttempts 为 1 时不重试
+	_, err := performBulkWithRetry(context.Background(), "products", bulkItems("1"), esClient)
+	if !IsTooManyRequests(err) {
+		t.Fatalf("expected 429 error, got %v", err)
+	}
+	if len(bs.bodies) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(bs.bodies))
+	}
+
+	SetRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, RetryableStatus: []int{429}})
+	bs.bodies = nil
+	if _, err := performBulkWithRetry(context.Background(), "products", bulkItems("1"), esClient); err != nil {
+		t.Fatal(err)
+	}
+	if len(bs.bodies) != 2 || !strings.Contains(bs.bodies[1], `"_id":"1"`) {
+		t.Fatalf("expected the request to be retried, got %q", bs.bodies)
+	}
+}
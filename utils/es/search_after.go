@@ -0,0 +1,212 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type searchAfterResponse struct {
+	PitID string `json:"pit_id"`
+	Hits  struct {
+		Hits []Hit `json:"hits"`
+	} `json:"hits"`
+}
+
+// SearchAfterIterator 基于 point in time + search_after 逐条遍历查询结果
+// 每次翻页都会续期 point in time，结束、出错或 Close 时关闭 point in time
+// 详见：https://www.elastic.co/guide/en/elasticsearch/reference/current/paginate-search-results.html#search-after
+type SearchAfterIterator struct {
+	hitCursor
+
+	esClient    *elasticsearch.Client
+	index       string
+	query       map[string]interface{}
+	keepAlive   time.Duration
+	pitID       string
+	searchAfter []interface{}
+}
+
+// NewSearchAfterIterator 创建 search_after 遍历器，第一次调用 Next 时才会打开 point in time
+// query 中的 sort 末尾会自动追加 tiebreaker 字段，保证翻页时顺序稳定
+func NewSearchAfterIterator(query map[string]interface{}, index string, esClient *elasticsearch.Client, opts ...IteratorOption) *SearchAfterIterator {
+	config := newIteratorConfig(query, opts)
+
+	body := copyQuery(query)
+	body["size"] = config.pageSize
+	body["sort"] = sortWithTiebreaker(query["sort"], config.tiebreaker)
+	// point in time 查询不能指定 from
+	delete(body, "from")
+
+	return &SearchAfterIterator{
+		hitCursor: hitCursor{pageSize: config.pageSize, pos: -1},
+		esClient:  esClient,
+		index:     index,
+		query:     body,
+		keepAlive: config.keepAlive,
+	}
+}
+
+// Next 移动到下一条记录，当前页读完时自动拉取下一页。没有更多记录或出错时返回 false
+func (it *SearchAfterIterator) Next(ctx context.Context) bool {
+	return it.next(ctx, it)
+}
+
+// Close 关闭 point in time，可重复调用
+func (it *SearchAfterIterator) Close() error {
+	it.done = true
+	return it.release()
+}
+
+func (it *SearchAfterIterator) fetch(ctx context.Context) ([]Hit, error) {
+	if it.pitID == "" {
+		pitID, err := openPointInTime(ctx, it.index, it.keepAlive, it.esClient)
+		if err != nil {
+			return nil, err
+		}
+		it.pitID = pitID
+	}
+
+	it.query["pit"] = map[string]interface{}{
+		"id":         it.pitID,
+		"keep_alive": formatDuration(it.keepAlive),
+	}
+	if it.searchAfter != nil {
+		it.query["search_after"] = it.searchAfter
+	}
+
+	reqBody, err := json.Marshal(it.query)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode query failed")
+	}
+	// point in time 已经绑定了索引，请求中不能再指定 index
+	start := time.Now()
+	res, err := performWithRetry(ctx, func() (*esapi.Response, error) {
+		return it.esClient.Search(
+			it.esClient.Search.WithContext(ctx),
+			it.esClient.Search.WithBody(bytes.NewReader(reqBody)),
+		)
+	})
+	var page searchAfterResponse
+	if err == nil {
+		err = decodeResponse(res, &page)
+	}
+	logRequest("Search", it.index, start, reqBody, logrus.Fields{"hits": len(page.Hits.Hits)}, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// 每次查询都可能返回新的 pit_id，后续请求必须使用最新的
+	if page.PitID != "" {
+		it.pitID = page.PitID
+	}
+	hits := page.Hits.Hits
+	if len(hits) > 0 {
+		it.searchAfter = hits[len(hits)-1].Sort
+	}
+	return hits, nil
+}
+
+func (it *SearchAfterIterator) release() error {
+	if it.pitID == "" {
+		return nil
+	}
+	pitID := it.pitID
+	it.pitID = ""
+	return closePointInTime(context.Background(), pitID, it.esClient)
+}
+
+func openPointInTime(ctx context.Context, index string, keepAlive time.Duration, esClient *elasticsearch.Client) (string, error) {
+	req := esapi.OpenPointInTimeRequest{
+		Index:     []string{index},
+		KeepAlive: formatDuration(keepAlive),
+	}
+	res, err := req.Do(ctx, esClient)
+	if err != nil {
+		return "", errors.Wrap(err, "open point in time failed")
+	}
+	var r struct {
+		ID string `json:"id"`
+	}
+	if err := decodeResponse(res, &r); err != nil {
+		return "", err
+	}
+	return r.ID, nil
+}
+
+func closePointInTime(ctx context.Context, pitID string, esClient *elasticsearch.Client) error {
+	body, err := json.Marshal(map[string]interface{}{"id": pitID})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req := esapi.ClosePointInTimeRequest{
+		Body: bytes.NewReader(body),
+	}
+	res, err := req.Do(ctx, esClient)
+	if err != nil {
+		return errors.Wrap(err, "close point in time failed")
+	}
+	defer res.Body.Close()
+	// point in time 已过期时返回 404，无需处理
+	if res.IsError() && res.StatusCode != 404 {
+		return responseError(res)
+	}
+	return nil
+}
+
+// sortWithTiebreaker 统一 sort 的格式并在末尾追加 tiebreaker，已包含时不重复追加
+func sortWithTiebreaker(sort interface{}, tiebreaker string) []interface{} {
+	var fields []interface{}
+	switch s := sort.(type) {
+	case nil:
+	case []interface{}:
+		fields = append(fields, s...)
+	case []map[string]interface{}:
+		for _, field := range s {
+			fields = append(fields, field)
+		}
+	case []string:
+		for _, field := range s {
+			fields = append(fields, field)
+		}
+	default:
+		fields = append(fields, s)
+	}
+
+	if tiebreaker == "" {
+		return fields
+	}
+	for _, field := range fields {
+		switch f := field.(type) {
+		case string:
+			if f == tiebreaker {
+				return fields
+			}
+		case map[string]interface{}:
+			if _, ok := f[tiebreaker]; ok {
+				return fields
+			}
+		}
+	}
+	return append(fields, map[string]interface{}{tiebreaker: "asc"})
+}
+
+// formatDuration 将 time.Duration 转换为 es 的时间单位格式，如 1m、30s
+func formatDuration(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	return fmt.Sprintf("%dms", d/time.Millisecond)
+}
@@ -0,0 +1,217 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// pitServer 模拟 point in time 与 search_after 接口，每次查询都返回新的 pit_id
+type pitServer struct {
+	t        *testing.T
+	products []testProduct
+	// failSearch 第几次查询返回错误，0 表示不出错
+	failSearch int
+
+	mu       sync.Mutex
+	requests []string
+	searches []map[string]interface{}
+	pitID    string
+	closed   []string
+}
+
+func (s *pitServer) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.URL.Path == "/products/_pit":
+		if keepAlive := r.URL.Query().Get("keep_alive"); keepAlive != "1m" {
+			s.t.Errorf("unexpected keep_alive %q", keepAlive)
+		}
+		s.pitID = "pit-0"
+		fmt.Fprintf(w, `{"id":%q}`, s.pitID)
+	case r.URL.Path == "/_pit" && r.Method == http.MethodDelete:
+		var req struct {
+			ID string `json:"id"`
+		}
+		_ = json.Unmarshal(body, &req)
+		s.closed = append(s.closed, req.ID)
+		fmt.Fprint(w, `{"succeeded":true,"num_freed":1}`)
+	case r.URL.Path == "/_search":
+		var query map[string]interface{}
+		if err := json.Unmarshal(body, &query); err != nil {
+			s.t.Error(err)
+		}
+		s.searches = append(s.searches, query)
+		if len(s.searches) == s.failSearch {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"type":"search_phase_execution_exception","reason":"all shards failed"},"status":400}`)
+			return
+		}
+		if pit, _ := query["pit"].(map[string]interface{}); pit["id"] != s.pitID || pit["keep_alive"] != "1m" {
+			s.t.Errorf("unexpected pit %v, current pit is %s", pit, s.pitID)
+		}
+
+		// sort 的最后一个值是 _shard_doc，即文档在 products 中的位置
+		start := 0
+		if after, ok := query["search_after"].([]interface{}); ok {
+			start = int(after[len(after)-1].(float64)) + 1
+		}
+		end := start + int(query["size"].(float64))
+		if end > len(s.products) {
+			end = len(s.products)
+		}
+		var hits []Hit
+		for i := start; i < end; i++ {
+			source, _ := json.Marshal(s.products[i])
+			hits = append(hits, Hit{Index: "products", ID: s.products[i].ID, Source: source, Sort: []interface{}{s.products[i].Price, i}})
+		}
+		s.pitID = fmt.Sprintf("pit-%d", len(s.searches))
+		response, _ := json.Marshal(map[string]interface{}{
+			"pit_id": s.pitID,
+			"hits":   map[string]interface{}{"hits": hits},
+		})
+		_, _ = w.Write(response)
+	default:
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newPITServer(t *testing.T, n int) *pitServer {
+	s := &pitServer{t: t}
+	for _, document := range testProducts(n) {
+		s.products = append(s.products, document.(testProduct))
+	}
+	return s
+}
+
+func TestSearchAfterIterator(t *testing.T) {
+	ps := newPITServer(t, 5)
+	server, esClient := newTestClient(t, ps.handle)
+	defer server.Close()
+
+	query := map[string]interface{}{
+		"query": NewMatchAllQuery().Map(),
+		"sort":  []interface{}{map[string]interface{}{"price": "asc"}},
+		"from":  10,
+	}
+	it := NewSearchAfterIterator(query, "products", esClient, WithPageSize(2))
+	var ids []string
+	for it.Next(context.Background()) {
+		var product testProduct
+		if err := it.Hit(&product); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, product.ID)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"1", "2", "3", "4", "5"}) {
+		t.Fatalf("unexpected documents %v", ids)
+	}
+	// 原查询不会被修改
+	if _, ok := query["pit"]; ok || query["from"] != 10 {
+		t.Fatalf("query was modified: %v", query)
+	}
+
+	// 最后一页不足 pageSize 时不再查询
+	if len(ps.searches) != 3 {
+		t.Fatalf("expected 3 searches, got %d", len(ps.searches))
+	}
+	var cursors []string
+	for _, search := range ps.searches {
+		if _, ok := search["from"]; ok {
+			t.Fatal("point in time search should not contain from")
+		}
+		sort, _ := json.Marshal(search["sort"])
+		if want := `[{"price":"asc"},{"_shard_doc":"asc"}]`; string(sort) != want {
+			t.Fatalf("expected sort %s, got %s", want, sort)
+		}
+		after, _ := json.Marshal(search["search_after"])
+		cursors = append(cursors, string(after))
+	}
+	if want := []string{"null", "[2,1]", "[4,3]"}; !reflect.DeepEqual(cursors, want) {
+		t.Fatalf("expected search_after %v, got %v", want, cursors)
+	}
+
+	// 遍历结束时使用最新的 pit_id 关闭 point in time，之后 Close 不会重复关闭
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ps.closed, []string{"pit-3"}) {
+		t.Fatalf("expected pit-3 to be closed, got %v", ps.closed)
+	}
+	want := []string{"POST /products/_pit", "GET /_search", "GET /_search", "GET /_search", "DELETE /_pit"}
+	if !reflect.DeepEqual(ps.requests, want) {
+		t.Fatalf("expected requests %v, got %v", want, ps.requests)
+	}
+}
+
+func TestSearchAfterIteratorClose(t *testing.T) {
+	ps := newPITServer(t, 5)
+	server, esClient := newTestClient(t, ps.handle)
+	defer server.Close()
+
+	// 没有查询时 Close 不发送请求
+	it := NewSearchAfterIterator(nil, "products", esClient, WithPageSize(2))
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ps.requests) != 0 {
+		t.Fatalf("unexpected requests %v", ps.requests)
+	}
+
+	it = NewSearchAfterIterator(nil, "products", esClient, WithPageSize(2))
+	if !it.Next(context.Background()) {
+		t.Fatal(it.Err())
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if it.Next(context.Background()) {
+		t.Fatal("Next should return false after Close")
+	}
+	if !reflect.DeepEqual(ps.closed, []string{"pit-1"}) {
+		t.Fatalf("expected pit-1 to be closed once, got %v", ps.closed)
+	}
+}
+
+func TestSearchAfterIteratorError(t *testing.T) {
+	ps := newPITServer(t, 5)
+	ps.failSearch = 2
+	server, esClient := newTestClient(t, ps.handle)
+	defer server.Close()
+
+	it := NewSearchAfterIterator(nil, "products", esClient, WithPageSize(2))
+	n := 0
+	for it.Next(context.Background()) {
+		n++
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 documents before the error, got %d", n)
+	}
+	var e *Error
+	if !errors.As(it.Err(), &e) || e.Type != "search_phase_execution_exception" {
+		t.Fatalf("unexpected error %v", it.Err())
+	}
+	// 出错时也会关闭 point in time
+	if !reflect.DeepEqual(ps.closed, []string{"pit-1"}) {
+		t.Fatalf("expected pit-1 to be closed, got %v", ps.closed)
+	}
+}
@@ -0,0 +1,158 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/pkg/errors"
+)
+
+var defaultPollInterval = 5 * time.Second
+
+// TaskStatus _reindex、_update_by_query、_delete_by_query 任务的进度
+type TaskStatus struct {
+	Total             int64   `json:"total"`
+	Created           int64   `json:"created"`
+	Updated           int64   `json:"updated"`
+	Deleted           int64   `json:"deleted"`
+	Batches           int64   `json:"batches"`
+	VersionConflicts  int64   `json:"version_conflicts"`
+	Noops             int64   `json:"noops"`
+	ThrottledMillis   int64   `json:"throttled_millis"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+}
+
+// Done 已处理的文档数
+func (s TaskStatus) Done() int64 {
+	return s.Created + s.Updated + s.Deleted + s.Noops + s.VersionConflicts
+}
+
+// TaskFailure 任务中单个文档或分片的错误
+type TaskFailure struct {
+	Index  string          `json:"index"`
+	ID     string          `json:"id"`
+	Status int             `json:"status"`
+	Cause  ErrorCause      `json:"cause"`
+	Shard  *int            `json:"shard,omitempty"`
+	Reason json.RawMessage `json:"reason,omitempty"`
+}
+
+// TaskResult 任务的当前状态，Completed 为 true 时 Response 为最终结果
+type TaskResult struct {
+	TaskID      string
+	Completed   bool
+	Description string
+	RunningTime time.Duration
+	Status      TaskStatus
+	Response    *TaskResponse
+	Error       *ErrorCause
+}
+
+// TaskResponse 任务完成后的结果
+type TaskResponse struct {
+	Took             int64         `json:"took"`
+	TimedOut         bool          `json:"timed_out"`
+	Total            int64         `json:"total"`
+	Created          int64         `json:"created"`
+	Updated          int64         `json:"updated"`
+	Deleted          int64         `json:"deleted"`
+	Batches          int64         `json:"batches"`
+	VersionConflicts int64         `json:"version_conflicts"`
+	Noops            int64         `json:"noops"`
+	Failures         []TaskFailure `json:"failures"`
+}
+
+// TaskError 任务执行完成，但有失败的文档或任务本身出错
+type TaskError struct {
+	TaskID   string
+	Cause    *ErrorCause
+	Failures []TaskFailure
+}
+
+func (e *TaskError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("task %s failed, %s: %s", e.TaskID, e.Cause.Type, e.Cause.Reason)
+	}
+	if len(e.Failures) > 0 {
+		first := e.Failures[0]
+		return fmt.Sprintf("task %s completed with %d failures, first: %s %s: %s",
+			e.TaskID, len(e.Failures), first.ID, first.Cause.Type, first.Cause.Reason)
+	}
+	return fmt.Sprintf("task %s failed", e.TaskID)
+}
+
+// GetTask 查询任务状态
+func GetTask(ctx context.Context, taskID string, esClient *elasticsearch.Client) (*TaskResult, error) {
+	var r struct {
+		Completed bool `json:"completed"`
+		Task      struct {
+			Description        string     `json:"description"`
+			RunningTimeInNanos int64      `json:"running_time_in_nanos"`
+			Status             TaskStatus `json:"status"`
+		} `json:"task"`
+		Response *TaskResponse `json:"response"`
+		Error    *ErrorCause   `json:"error"`
+	}
+	err := doRequest(ctx, esClient, &r, func() esapi.Request {
+		return esapi.TasksGetRequest{TaskID: taskID}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TaskResult{
+		TaskID:      taskID,
+		Completed:   r.Completed,
+		Description: r.Task.Description,
+		RunningTime: time.Duration(r.Task.RunningTimeInNanos),
+		Status:      r.Task.Status,
+		Response:    r.Response,
+		Error:       r.Error,
+	}, nil
+}
+
+// WaitForTask 每隔 interval 查询一次任务状态直到任务完成，onProgress 不为空时每次查询后回调
+// 任务出错或有失败的文档时返回 *TaskError，同时返回任务结果
+func WaitForTask(ctx context.Context, taskID string, interval time.Duration, onProgress func(*TaskResult), esClient *elasticsearch.Client) (*TaskResult, error) {
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	for {
+		result, err := GetTask(ctx, taskID, esClient)
+		if err != nil {
+			return nil, err
+		}
+		if onProgress != nil {
+			onProgress(result)
+		}
+		if result.Completed {
+			if result.Error != nil {
+				return result, &TaskError{TaskID: taskID, Cause: result.Error}
+			}
+			if result.Response != nil && len(result.Response.Failures) > 0 {
+				return result, &TaskError{TaskID: taskID, Failures: result.Response.Failures}
+			}
+			return result, nil
+		}
+		if err := sleepContext(ctx, interval); err != nil {
+			return result, err
+		}
+	}
+}
+
+// startTask 以 wait_for_completion=false 方式提交的请求返回的任务 ID
+func startTask(ctx context.Context, esClient *elasticsearch.Client, newRequest func() esapi.Request) (string, error) {
+	var r struct {
+		Task string `json:"task"`
+	}
+	if err := doRequest(ctx, esClient, &r, newRequest); err != nil {
+		return "", err
+	}
+	if r.Task == "" {
+		return "", errors.New("no task id in response")
+	}
+	return r.Task, nil
+}
@@ -0,0 +1,418 @@
+package mongo
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+const (
+	// maxBSONSize 单个文档以及单次写入的最大字节数
+	maxBSONSize = 16 * 1024 * 1024
+	// maxWriteBatchSize 单次写入的最大文档数
+	maxWriteBatchSize = 100000
+)
+
+var defaultFlushInterval = 30 * time.Second
+
+// FailureKind 文档写入失败的原因分类
+type FailureKind string
+
+const (
+	FailureDuplicateKey FailureKind = "duplicate_key" // 唯一索引冲突，重试不会成功
+	FailureValidation   FailureKind = "validation"    // 文档不满足集合的 validator
+	FailureNetwork      FailureKind = "network"       // 网络错误、超时、无法选择节点，可以重试
+	FailureAborted      FailureKind = "aborted"       // ordered 模式下同批中前面的文档失败，该文档没有执行，可以重试
+	FailureOther        FailureKind = "other"
+)
+
+// WriteFailure 单个文档写入失败的详情
+type WriteFailure struct {
+	Kind    FailureKind
+	Code    int // 服务端错误码，请求失败时为 0
+	Message string
+	Err     error // 整个请求失败时的原始错误
+}
+
+// BulkWriterConfig BulkWriter 配置
+type BulkWriterConfig struct {
+	// Ordered 每批文档按顺序执行，遇到失败时同批后续文档不再执行，后续批次仍会发送；
+	// 为 true 时 NumWorkers 固定为 1，保证所有文档按 Add 的顺序写入
+	Ordered       bool
+	NumWorkers    int           // 并发写入的 worker 数，默认 runtime.NumCPU()
+	FlushBytes    int           // 单次写入达到该字节数时发送，默认及最大 16MB
+	FlushItems    int           // 单次写入达到该文档数时发送，默认 20000，最大 100000
+	FlushInterval time.Duration // 距离上次发送超过该时间时发送，默认 30s
+
+	// Key upsert 操作没有指定 Filter 时用于生成 filter，默认与 PerformMongoDBUpsert 相同
+	Key KeyFunc
+
+	// OnError 整个请求失败，或文档已写入但 write concern 不满足时回调
+	OnError func(err error)
+}
+
+// BulkWriterItem 一次写入操作
+type BulkWriterItem struct {
+	Action   string      // insert、upsert、delete
+	Document interface{} // insert、upsert 的文档，upsert 时按 $set 更新
+	Filter   interface{} // delete 的 filter，只删除一条；upsert 为空时使用 BulkWriterConfig.Key 生成
+
+	// OnSuccess 文档写入成功时回调
+	OnSuccess func(item BulkWriterItem)
+	// OnFailure 文档写入失败时回调
+	OnFailure func(item BulkWriterItem, failure WriteFailure)
+}
+
+// BulkWriterStats BulkWriter 统计信息
+type BulkWriterStats struct {
+	NumAdded    uint64
+	NumWritten  uint64
+	NumFailed   uint64
+	NumRequests uint64
+}
+
+// BulkWriter 增量接收文档，按文档数量、字节数或时间间隔分批并发写入 mongo，
+// 通过 OnFailure 回调报告每个失败的文档及原因，调用方可以只重试这些文档
+//
+//	bw, err := mongo.NewBulkWriter(mongo.BulkWriterConfig{}, collection)
+//	...
+//	err = bw.Add(ctx, mongo.BulkWriterItem{
+//		Action:   "insert",
+//		Document: document,
+//		OnFailure: func(item mongo.BulkWriterItem, failure mongo.WriteFailure) {
+//			if failure.Kind == mongo.FailureNetwork {
+//				retry = append(retry, item)
+//			}
+//		},
+//	})
+//	...
+//	err = bw.Close(ctx)
+type BulkWriter struct {
+	// 放在最前面，保证 32 位平台上原子操作的内存对齐
+	stats BulkWriterStats
+
+	config     BulkWriterConfig
+	collection *mongo.Collection
+	queue      chan *bulkWriterItem
+	wg         sync.WaitGroup
+	mu         sync.RWMutex
+	closed     bool
+
+	// ctx 用于所有写入请求，Close 超时时取消，未完成的写入以 ctx 的错误失败
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type bulkWriterItem struct {
+	BulkWriterItem
+	model mongo.WriteModel
+	size  int
+}
+
+// NewBulkWriter 创建 BulkWriter 并启动 worker，使用完毕后必须调用 Close
+func NewBulkWriter(config BulkWriterConfig, collection *mongo.Collection) (*BulkWriter, error) {
+	if collection == nil {
+		return nil, errors.New("collection can not be nil")
+	}
+	if config.NumWorkers <= 0 {
+		config.NumWorkers = runtime.NumCPU()
+	}
+	if config.Ordered {
+		config.NumWorkers = 1
+	}
+	if config.FlushBytes <= 0 || config.FlushBytes > maxBSONSize {
+		config.FlushBytes = maxBSONSize
+	}
+	if config.FlushItems <= 0 {
+		config.FlushItems = batchSize
+	}
+	if config.FlushItems > maxWriteBatchSize {
+		config.FlushItems = maxWriteBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultFlushInterval
+	}
+	if config.Key == nil {
+		config.Key = defaultKey
+	}
+
+	bw := &BulkWriter{
+		config:     config,
+		collection: collection,
+		queue:      make(chan *bulkWriterItem, config.NumWorkers),
+	}
+	bw.ctx, bw.cancel = context.WithCancel(context.Background())
+	bw.wg.Add(config.NumWorkers)
+	for i := 0; i < config.NumWorkers; i++ {
+		w := &bulkWriterWorker{bw: bw}
+		go w.run()
+	}
+	return bw, nil
+}
+
+// Add 添加一个操作，文档在 Add 时序列化，序列化失败或文档超过 16MB 时直接返回错误
+func (bw *BulkWriter) Add(ctx context.Context, item BulkWriterItem) error {
+	model, size, err := writeModelOf(item, bw.config.Key)
+	if err != nil {
+		return err
+	}
+
+	bw.mu.RLock()
+	defer bw.mu.RUnlock()
+	if bw.closed {
+		return errors.New("bulk writer is closed")
+	}
+
+	select {
+	case bw.queue <- &bulkWriterItem{BulkWriterItem: item, model: model, size: size}:
+		atomic.AddUint64(&bw.stats.NumAdded, 1)
+		return nil
+	case <-ctx.Done():
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// Close 停止接收文档，并等待所有已添加的文档写入完成
+// ctx 结束时取消正在进行的写入，尚未写入的文档通过 OnFailure 报告失败，Close 返回 ctx 的错误
+func (bw *BulkWriter) Close(ctx context.Context) error {
+	bw.mu.Lock()
+	if !bw.closed {
+		bw.closed = true
+		close(bw.queue)
+	}
+	bw.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		bw.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		bw.cancel()
+		return nil
+	case <-ctx.Done():
+		bw.cancel()
+		return errors.WithStack(ctx.Err())
+	}
+}
+
+// Stats 返回统计信息
+func (bw *BulkWriter) Stats() BulkWriterStats {
+	return BulkWriterStats{
+		NumAdded:    atomic.LoadUint64(&bw.stats.NumAdded),
+		NumWritten:  atomic.LoadUint64(&bw.stats.NumWritten),
+		NumFailed:   atomic.LoadUint64(&bw.stats.NumFailed),
+		NumRequests: atomic.LoadUint64(&bw.stats.NumRequests),
+	}
+}
+
+type bulkWriterWorker struct {
+	bw    *BulkWriter
+	size  int
+	items []*bulkWriterItem
+}
+
+func (w *bulkWriterWorker) run() {
+	defer w.bw.wg.Done()
+
+	ticker := time.NewTicker(w.bw.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case item, ok := <-w.bw.queue:
+			if !ok {
+				w.flush()
+				return
+			}
+			// 加入当前文档会超出大小限制时，先写入已有的文档
+			if len(w.items) > 0 && w.size+item.size > w.bw.config.FlushBytes {
+				w.flush()
+			}
+			w.size += item.size
+			w.items = append(w.items, item)
+			if len(w.items) >= w.bw.config.FlushItems || w.size >= w.bw.config.FlushBytes {
+				w.flush()
+			}
+		case <-ticker.C:
+			w.flush()
+		}
+	}
+}
+
+func (w *bulkWriterWorker) flush() {
+	if len(w.items) == 0 {
+		return
+	}
+	defer func() {
+		w.size = 0
+		w.items = w.items[:0]
+	}()
+
+	bw := w.bw
+	atomic.AddUint64(&bw.stats.NumRequests, 1)
+
+	models := make([]mongo.WriteModel, len(w.items))
+	for i, item := range w.items {
+		models[i] = item.model
+	}
+	opts := options.BulkWrite().SetOrdered(bw.config.Ordered)
+	_, err := bw.collection.BulkWrite(bw.ctx, models, opts)
+
+	failures := w.failures(err)
+	for i, item := range w.items {
+		if failure, ok := failures[i]; ok {
+			atomic.AddUint64(&bw.stats.NumFailed, 1)
+			if item.OnFailure != nil {
+				item.OnFailure(item.BulkWriterItem, failure)
+			}
+			continue
+		}
+		atomic.AddUint64(&bw.stats.NumWritten, 1)
+		if item.OnSuccess != nil {
+			item.OnSuccess(item.BulkWriterItem)
+		}
+	}
+}
+
+// failures 返回本批中失败的文档，key 为文档在本批中的序号
+func (w *bulkWriterWorker) failures(err error) map[int]WriteFailure {
+	if err == nil {
+		return nil
+	}
+	bw := w.bw
+	failures := make(map[int]WriteFailure)
+
+	var exception mongo.BulkWriteException
+	if !errors.As(err, &exception) {
+		if bw.config.OnError != nil {
+			bw.config.OnError(errors.Wrap(err, "mongo bulk write failed"))
+		}
+		failure := WriteFailure{Kind: requestFailureKind(err), Message: err.Error(), Err: err}
+		for i := range w.items {
+			failures[i] = failure
+		}
+		return failures
+	}
+
+	if exception.WriteConcernError != nil && bw.config.OnError != nil {
+		bw.config.OnError(writeErrorOf("bulk write", err, 0))
+	}
+	firstFailed := len(w.items)
+	for _, we := range exception.WriteErrors {
+		failures[we.Index] = WriteFailure{Kind: writeFailureKind(we.Code), Code: we.Code, Message: we.Message}
+		if we.Index < firstFailed {
+			firstFailed = we.Index
+		}
+	}
+	if bw.config.Ordered {
+		for i := firstFailed + 1; i < len(w.items); i++ {
+			if _, ok := failures[i]; !ok {
+				failures[i] = WriteFailure{Kind: FailureAborted, Message: "not executed because a previous document failed"}
+			}
+		}
+	}
+	return failures
+}
+
+// writeModelOf 生成 item 对应的 WriteModel，返回序列化后的字节数
+func writeModelOf(item BulkWriterItem, keyFunc KeyFunc) (mongo.WriteModel, int, error) {
+	switch item.Action {
+	case "insert":
+		document, err := marshalDocument(item.Document)
+		if err != nil {
+			return nil, 0, err
+		}
+		return mongo.NewInsertOneModel().SetDocument(document), len(document), nil
+
+	case "upsert":
+		document, err := marshalDocument(item.Document)
+		if err != nil {
+			return nil, 0, err
+		}
+		filter := item.Filter
+		if filter == nil {
+			key, err := keyFunc(item.Document)
+			if err != nil {
+				return nil, 0, err
+			}
+			if len(key) == 0 {
+				return nil, 0, errors.New("document has empty key")
+			}
+			filter = key
+		}
+		rawFilter, err := marshalDocument(filter)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "filter")
+		}
+		model := mongo.NewUpdateOneModel().
+			SetFilter(rawFilter).
+			SetUpdate(bson.M{"$set": document}).
+			SetUpsert(true)
+		return model, len(rawFilter) + len(document), nil
+
+	case "delete":
+		if item.Filter == nil {
+			return nil, 0, errors.New("delete requires a filter")
+		}
+		rawFilter, err := marshalDocument(item.Filter)
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "filter")
+		}
+		return mongo.NewDeleteOneModel().SetFilter(rawFilter), len(rawFilter), nil
+	}
+	return nil, 0, errors.Errorf("unsupported bulk action %q", item.Action)
+}
+
+func marshalDocument(document interface{}) (bson.Raw, error) {
+	if document == nil {
+		return nil, errors.New("document can not be nil")
+	}
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode document failed")
+	}
+	if len(raw) > maxBSONSize {
+		return nil, errors.Errorf("document size %d exceeds the 16MB limit", len(raw))
+	}
+	return raw, nil
+}
+
+// writeFailureKind 按服务端错误码分类单个文档的错误
+func writeFailureKind(code int) FailureKind {
+	switch code {
+	case 11000, 11001, 12582:
+		return FailureDuplicateKey
+	case 121:
+		// DocumentValidationFailure
+		return FailureValidation
+	}
+	return FailureOther
+}
+
+// requestFailureKind 分类整个请求的错误
+func requestFailureKind(err error) FailureKind {
+	if isTransientError(err) {
+		return FailureNetwork
+	}
+	return FailureOther
+}
+
+// isTransientError 网络错误、超时、无法选择节点、建立连接失败
+func isTransientError(err error) bool {
+	var (
+		selectionErr  topology.ServerSelectionError
+		connectionErr topology.ConnectionError
+	)
+	return mongo.IsNetworkError(err) || mongo.IsTimeout(err) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &selectionErr) || errors.As(err, &connectionErr)
+}
@@ -0,0 +1,200 @@
+package mongo
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+func testWorker(ordered bool, n int) (*bulkWriterWorker, *[]error) {
+	var errs []error
+	bw := &BulkWriter{config: BulkWriterConfig{
+		Ordered: ordered,
+		OnError: func(err error) { errs = append(errs, err) },
+	}}
+	w := &bulkWriterWorker{bw: bw}
+	for i := 0; i < n; i++ {
+		w.items = append(w.items, &bulkWriterItem{})
+	}
+	return w, &errs
+}
+
+func bulkWriteException(errs ...mongo.WriteError) mongo.BulkWriteException {
+	var exception mongo.BulkWriteException
+	for _, we := range errs {
+		exception.WriteErrors = append(exception.WriteErrors, mongo.BulkWriteError{WriteError: we})
+	}
+	return exception
+}
+
+func failureKinds(failures map[int]WriteFailure) map[int]FailureKind {
+	kinds := make(map[int]FailureKind, len(failures))
+	for i, failure := range failures {
+		kinds[i] = failure.Kind
+	}
+	return kinds
+}
+
+func TestBulkWriterFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		ordered bool
+		err     error
+		want    map[int]FailureKind
+		onError bool
+	}{
+		{
+			name: "success",
+			want: map[int]FailureKind{},
+		},
+		{
+			name: "unordered write errors",
+			err: bulkWriteException(
+				mongo.WriteError{Index: 1, Code: 11000, Message: "E11000 duplicate key error"},
+				mongo.WriteError{Index: 3, Code: 121, Message: "Document failed validation"},
+			),
+			want: map[int]FailureKind{1: FailureDuplicateKey, 3: FailureValidation},
+		},
+		{
+			name:    "ordered abort",
+			ordered: true,
+			err:     bulkWriteException(mongo.WriteError{Index: 1, Code: 11000, Message: "E11000 duplicate key error"}),
+			want:    map[int]FailureKind{1: FailureDuplicateKey, 2: FailureAborted, 3: FailureAborted},
+		},
+		{
+			name:    "server selection",
+			err:     topology.ServerSelectionError{Wrapped: errors.New("server selection timeout")},
+			want:    map[int]FailureKind{0: FailureNetwork, 1: FailureNetwork, 2: FailureNetwork, 3: FailureNetwork},
+			onError: true,
+		},
+		{
+			name:    "deadline exceeded",
+			err:     errors.WithStack(context.DeadlineExceeded),
+			want:    map[int]FailureKind{0: FailureNetwork, 1: FailureNetwork, 2: FailureNetwork, 3: FailureNetwork},
+			onError: true,
+		},
+		{
+			name:    "other request error",
+			err:     errors.New("unauthorized"),
+			want:    map[int]FailureKind{0: FailureOther, 1: FailureOther, 2: FailureOther, 3: FailureOther},
+			onError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, errs := testWorker(tt.ordered, 4)
+			failures := w.failures(tt.err)
+			if got := failureKinds(failures); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			if tt.onError != (len(*errs) > 0) {
+				t.Fatalf("unexpected OnError calls %v", *errs)
+			}
+		})
+	}
+}
+
+func TestBulkWriterFailureDetails(t *testing.T) {
+	w, _ := testWorker(false, 2)
+	failures := w.failures(bulkWriteException(mongo.WriteError{Index: 0, Code: 11000, Message: "E11000 duplicate key error"}))
+	want := WriteFailure{Kind: FailureDuplicateKey, Code: 11000, Message: "E11000 duplicate key error"}
+	if failures[0] != want {
+		t.Fatalf("expected %+v, got %+v", want, failures[0])
+	}
+
+	requestErr := errors.New("unauthorized")
+	failures = w.failures(requestErr)
+	if failures[1].Err != requestErr || failures[1].Code != 0 {
+		t.Fatalf("expected the request error, got %+v", failures[1])
+	}
+}
+
+func TestWriteModelOf(t *testing.T) {
+	document := struct {
+		EntityID   string `bson:"entity_id"`
+		EntityType string `bson:"entity_type"`
+	}{EntityID: "1", EntityType: "user"}
+
+	tests := []struct {
+		name    string
+		item    BulkWriterItem
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "insert", item: BulkWriterItem{Action: "insert", Document: document}, want: &mongo.InsertOneModel{}},
+		{name: "upsert", item: BulkWriterItem{Action: "upsert", Document: document}, want: &mongo.UpdateOneModel{}},
+		{name: "delete", item: BulkWriterItem{Action: "delete", Filter: map[string]string{"_id": "1"}}, want: &mongo.DeleteOneModel{}},
+		{name: "insert nil", item: BulkWriterItem{Action: "insert"}, wantErr: true},
+		{name: "upsert without key", item: BulkWriterItem{Action: "upsert", Document: map[string]string{"a": "b"}}, wantErr: true},
+		{name: "delete without filter", item: BulkWriterItem{Action: "delete"}, wantErr: true},
+		{name: "unsupported action", item: BulkWriterItem{Action: "replace", Document: document}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, size, err := writeModelOf(tt.item, defaultKey)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reflect.TypeOf(model) != reflect.TypeOf(tt.want) || size <= 0 {
+				t.Fatalf("unexpected model %T with size %d", model, size)
+			}
+		})
+	}
+}
+
+func TestBulkWriterCloseCancel(t *testing.T) {
+	// 连接不存在的服务，写入会一直阻塞在选择节点上，直到 Close 超时取消
+	opts := options.Client().ApplyURI("mongodb://127.0.0.1:1").SetServerSelectionTimeout(time.Minute)
+	client, err := mongo.Connect(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Disconnect(context.Background())
+
+	var (
+		mu       sync.Mutex
+		failures []WriteFailure
+	)
+	bw, err := NewBulkWriter(BulkWriterConfig{NumWorkers: 1, FlushItems: 1}, client.Database("test").Collection("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bw.Add(context.Background(), BulkWriterItem{
+		Action:   "insert",
+		Document: map[string]string{"a": "b"},
+		OnFailure: func(item BulkWriterItem, failure WriteFailure) {
+			mu.Lock()
+			defer mu.Unlock()
+			failures = append(failures, failure)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := bw.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	// 取消后 worker 很快退出，文档报告失败
+	bw.wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failures) != 1 {
+		t.Fatalf("expected 1 failure, got %d", len(failures))
+	}
+}
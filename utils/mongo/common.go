@@ -2,17 +2,17 @@ package mongo
 
 import (
 	"context"
-	"errors"
 	"log"
-	"reflect"
 
+	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var batchSize = 20000
 
-// PerformMongoDBInsert ...
+// PerformMongoDBInsert 批量插入文档，部分文档写入失败时返回 *BulkWriteError
 func PerformMongoDBInsert(documents []interface{}, collection *mongo.Collection) error {
 
 	if collection == nil {
@@ -21,32 +21,43 @@ func PerformMongoDBInsert(documents []interface{}, collection *mongo.Collection)
 	if len(documents) != 0 {
 		_, err := collection.InsertMany(context.TODO(), documents)
 		if err != nil {
-			log.Fatal(err)
-			return err
+			return writeErrorOf("insert", err, 0)
 		}
 	}
 	return nil
 }
 
-// PerformMongoDBUpsert 批量更新插入操作,当 entity_id,entity_type 的联合主键记录存在时，更新记录，否则添加记录
-// TODO: mongo document 变化后未测试
+// PerformMongoDBUpsert 批量更新插入操作，key 相同的记录存在时更新记录，否则添加记录
+// key 由文档中带有 mongoutil:"key" tag 的字段确定，见 KeyByTag；
+// 没有该 tag 时兼容以前的行为，使用 EntityID、EntityType 字段作为 entity_document.entity_id、entity_document.entity_type
 func PerformMongoDBUpsert(documents []interface{}, collection *mongo.Collection) error {
+	return PerformMongoDBUpsertWithKey(documents, defaultKey, collection)
+}
+
+// PerformMongoDBUpsertWithKey 同 PerformMongoDBUpsert，使用 keyFunc 返回的 filter 确定记录
+// 部分文档写入失败时返回 *BulkWriteError
+func PerformMongoDBUpsertWithKey(documents []interface{}, keyFunc KeyFunc, collection *mongo.Collection) error {
 
 	if collection == nil {
 		return errors.New("collection can not be nil")
 	}
+	if keyFunc == nil {
+		return errors.New("keyFunc can not be nil")
+	}
 	if len(documents) == 0 {
 		return nil
 	}
 	writeModels := []mongo.WriteModel{}
 	upsertFlag := true
 	for i := range documents {
-		value := reflect.ValueOf(documents[i]).Elem()
-		filter := make(bson.M, 0)
-		update := make(bson.M, 0)
-		filter["entity_document.entity_id"] = value.FieldByName("EntityID").Interface()
-		filter["entity_document.entity_type"] = value.FieldByName("EntityType").Interface()
-		update["$set"] = documents[i]
+		filter, err := keyFunc(documents[i])
+		if err != nil {
+			return errors.Wrapf(err, "document %d", i)
+		}
+		if len(filter) == 0 {
+			return errors.Errorf("document %d has empty key", i)
+		}
+		update := bson.M{"$set": documents[i]}
 		updateOneModel := mongo.UpdateOneModel{
 			Filter: filter,
 			Update: update,
@@ -55,39 +66,84 @@ func PerformMongoDBUpsert(documents []interface{}, collection *mongo.Collection)
 		writeModels = append(writeModels, &updateOneModel)
 	}
 	if _, err := collection.BulkWrite(context.TODO(), writeModels); err != nil {
-		log.Fatal(err)
-		return err
+		return writeErrorOf("upsert", err, 0)
 	}
 	return nil
 }
 
-// PerformMongoDBDelete ...
+// defaultKey 优先使用 mongoutil:"key" tag，结构体中没有带 tag 的字段时使用 EntityID、EntityType 字段
+// 带 tag 的字段为空等其他错误直接返回，不会退回到 EntityID、EntityType，避免更新错误的文档
+func defaultKey(document interface{}) (bson.M, error) {
+	filter, err := KeyByTag(document)
+	if err == nil || errors.Cause(err) != errNoKeyFields {
+		return filter, err
+	}
+	legacy, ok, legacyErr := legacyKey(document)
+	if !ok {
+		return nil, err
+	}
+	if legacyErr != nil {
+		return nil, legacyErr
+	}
+	return legacy, nil
+}
+
+// PerformMongoDBDelete 按 _id 分批删除文档
 func PerformMongoDBDelete(ids []string, collection *mongo.Collection) error {
+	_, err := PerformMongoDBDeleteContext(context.Background(), ids, "", collection)
+	return err
+}
+
+// PerformMongoDBDeleteContext 按 field 分批删除 field 的值在 ids 中的文档，返回删除的文档总数
+// field 为空时使用 _id，_id 为合法的 ObjectID hex 时同时匹配 ObjectID 与字符串形式的 _id
+// 某一批删除失败时返回之前已删除的文档数以及错误
+func PerformMongoDBDeleteContext(ctx context.Context, ids []string, field string, collection *mongo.Collection) (int64, error) {
 
-	log.Println("perform mongo document delete. Collection: ", collection.Name(), ". Total: ", len(ids))
 	if collection == nil {
-		return errors.New("collection can not be nil")
+		return 0, errors.New("collection can not be nil")
+	}
+	if field == "" {
+		field = "_id"
 	}
+	log.Println("perform mongo document delete. Collection: ", collection.Name(), ". Total: ", len(ids))
+
+	var deletedCount int64
 	for i := 0; i < len(ids); i += batchSize {
 		endIndex := i + batchSize
 		if endIndex > len(ids) {
 			endIndex = len(ids)
 		}
 		filter := bson.M{
-			"ids": bson.M{
-				"$in": ids,
+			field: bson.M{
+				"$in": deleteValues(ids[i:endIndex], field),
 			},
 		}
-		if _, err := collection.DeleteMany(context.TODO(), filter); err != nil {
-			log.Fatal(err)
-			return err
+		deleteResult, err := collection.DeleteMany(ctx, filter)
+		if err != nil {
+			return deletedCount, errors.Wrapf(err, "delete documents %d-%d failed", i, endIndex)
 		}
+		deletedCount += deleteResult.DeletedCount
 	}
 
-	return nil
+	return deletedCount, nil
+}
+
+// deleteValues field 为 _id 时，将合法的 ObjectID hex 转换为 ObjectID，同时保留字符串形式
+func deleteValues(ids []string, field string) []interface{} {
+	values := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, id)
+		if field != "_id" {
+			continue
+		}
+		if objectID, err := primitive.ObjectIDFromHex(id); err == nil {
+			values = append(values, objectID)
+		}
+	}
+	return values
 }
 
-// DeleteMongoCollection ...
+// DeleteMongoCollection 删除集合中的所有文档
 func DeleteMongoCollection(collection *mongo.Collection) error {
 
 	if collection == nil {
@@ -99,8 +155,7 @@ func DeleteMongoCollection(collection *mongo.Collection) error {
 	filter := bson.M{}
 	deleteResult, err := collection.DeleteMany(context.TODO(), filter)
 	if err != nil {
-		log.Fatal(err)
-		return err
+		return errors.Wrapf(err, "delete all documents of %s failed", collection.Name())
 	}
 
 	log.Println("delete mongo all documents. Collection: ", collection.Name(), ". DeletedCount: ", deleteResult.DeletedCount)
@@ -0,0 +1,80 @@
+package mongo
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// WriteError 批量写入中单个文档的错误
+type WriteError struct {
+	Index   int // 文档在 documents 中的序号，从 0 开始
+	Code    int
+	Message string
+}
+
+// BulkWriteError InsertMany、BulkWrite 部分文档写入失败或 write concern 不满足
+// 可以根据 WriteErrors 中的 Index 重试或单独记录失败的文档
+//
+//	err := mongo.PerformMongoDBInsert(documents, collection)
+//	var e *mongo.BulkWriteError
+//	if errors.As(err, &e) {
+//		for _, we := range e.WriteErrors {
+//			log.Println(we.Index, we.Message)
+//		}
+//	}
+//
+// Unwrap 返回 driver 的原始错误，driver 的 mongo.IsDuplicateKeyError 等函数仍然可用
+type BulkWriteError struct {
+	Op                string // insert、upsert
+	WriteErrors       []WriteError
+	WriteConcernError *mongo.WriteConcernError
+	Labels            []string
+	err               error
+}
+
+func (e *BulkWriteError) Error() string {
+	msg := fmt.Sprintf("mongo %s: %d documents failed", e.Op, len(e.WriteErrors))
+	if len(e.WriteErrors) > 0 {
+		first := e.WriteErrors[0]
+		msg += fmt.Sprintf(", first: document %d [%d] %s", first.Index, first.Code, first.Message)
+	}
+	if e.WriteConcernError != nil {
+		msg += ", write concern error: " + e.WriteConcernError.Error()
+	}
+	return msg
+}
+
+// Unwrap 返回 driver 的 mongo.BulkWriteException
+func (e *BulkWriteError) Unwrap() error {
+	return e.err
+}
+
+// Cause 同 Unwrap，兼容 errors.Cause
+func (e *BulkWriteError) Cause() error {
+	return e.err
+}
+
+// writeErrorOf driver 返回 mongo.BulkWriteException 时转换为 *BulkWriteError，
+// offset 为这一批文档在整个 documents 中的偏移；其他错误直接附加 op 返回
+func writeErrorOf(op string, err error, offset int) error {
+	var exception mongo.BulkWriteException
+	if !errors.As(err, &exception) {
+		return errors.Wrapf(err, "mongo %s failed", op)
+	}
+	e := &BulkWriteError{
+		Op:                op,
+		WriteConcernError: exception.WriteConcernError,
+		Labels:            exception.Labels,
+		err:               err,
+	}
+	for _, we := range exception.WriteErrors {
+		e.WriteErrors = append(e.WriteErrors, WriteError{
+			Index:   we.Index + offset,
+			Code:    we.Code,
+			Message: we.Message,
+		})
+	}
+	return errors.WithStack(e)
+}
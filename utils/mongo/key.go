@@ -0,0 +1,234 @@
+package mongo
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// KeyFunc 返回文档在 upsert 时使用的 filter，filter 中的字段共同确定一条记录
+type KeyFunc func(document interface{}) (bson.M, error)
+
+// keyField 带有 mongoutil:"key" tag 的字段
+type keyField struct {
+	name  string // bson 中的字段名，嵌套结构体中的字段为 a.b 形式
+	index []int  // reflect 字段下标路径
+}
+
+var keyFieldsCache sync.Map // reflect.Type -> []keyField
+
+// errNoKeyFields 结构体中没有带 mongoutil:"key" tag 的字段，只有这种情况下 defaultKey 才使用 legacyKey
+var errNoKeyFields = errors.New(`no field tagged with mongoutil:"key"`)
+
+// KeyByTag 使用结构体中带有 mongoutil:"key" tag 的字段作为 key，字段名使用 bson tag
+// 多个字段组成联合 key，嵌套结构体中的字段使用 a.b 形式的字段名，inline 的结构体不增加前缀
+//
+//	type Entity struct {
+//		Document EntityDocument `bson:"entity_document"`
+//	}
+//	type EntityDocument struct {
+//		EntityID   string `bson:"entity_id" mongoutil:"key"`
+//		EntityType string `bson:"entity_type" mongoutil:"key"`
+//	}
+//
+// 上面的结构体生成 {"entity_document.entity_id": ..., "entity_document.entity_type": ...}
+func KeyByTag(document interface{}) (bson.M, error) {
+	value := reflect.ValueOf(document)
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil, errors.New("document is nil")
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, errors.Errorf("document must be a struct, got %T", document)
+	}
+
+	fields, err := keyFieldsOf(value.Type())
+	if err != nil {
+		return nil, err
+	}
+	filter := make(bson.M, len(fields))
+	for _, field := range fields {
+		v, err := fieldByIndex(value, field.index)
+		if err == nil && v.Kind() == reflect.Ptr && v.IsNil() {
+			err = errors.New("is nil")
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "key field %s", field.name)
+		}
+		filter[field.name] = v.Interface()
+	}
+	return filter, nil
+}
+
+// KeyByFields 使用文档中的指定字段作为 key，字段名为 bson 中的字段名，可以是 a.b 形式
+// 文档会先被序列化为 bson，因此结构体、map、bson.D 都可以使用
+//
+//	err := mongo.PerformMongoDBUpsertWithKey(documents, mongo.KeyByFields("tenant_id", "user_id"), collection)
+func KeyByFields(fields ...string) KeyFunc {
+	return func(document interface{}) (bson.M, error) {
+		if len(fields) == 0 {
+			return nil, errors.New("no key fields")
+		}
+		raw, err := bson.Marshal(document)
+		if err != nil {
+			return nil, errors.Wrap(err, "encode document failed")
+		}
+		filter := make(bson.M, len(fields))
+		for _, field := range fields {
+			value, err := bson.Raw(raw).LookupErr(strings.Split(field, ".")...)
+			if err != nil {
+				return nil, errors.Errorf("key field %s is missing", field)
+			}
+			filter[field] = value
+		}
+		return filter, nil
+	}
+}
+
+// legacyKey 没有 mongoutil:"key" tag 时，兼容以前使用 EntityID、EntityType 字段作为 key 的行为
+// 没有这两个字段时 ok 为 false；字段不可访问或者路径上嵌入的指针为空时返回错误，而不是 panic
+func legacyKey(document interface{}) (filter bson.M, ok bool, err error) {
+	value := reflect.ValueOf(document)
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil, false, nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, false, nil
+	}
+	idField, hasID := value.Type().FieldByName("EntityID")
+	typeField, hasType := value.Type().FieldByName("EntityType")
+	if !hasID || !hasType {
+		return nil, false, nil
+	}
+	entityID, err := legacyField(value, idField)
+	if err != nil {
+		return nil, true, err
+	}
+	entityType, err := legacyField(value, typeField)
+	if err != nil {
+		return nil, true, err
+	}
+	return bson.M{
+		"entity_document.entity_id":   entityID,
+		"entity_document.entity_type": entityType,
+	}, true, nil
+}
+
+func legacyField(value reflect.Value, field reflect.StructField) (interface{}, error) {
+	v, err := fieldByIndex(value, field.Index)
+	if err != nil {
+		return nil, errors.Wrapf(err, "key field %s", field.Name)
+	}
+	if !v.CanInterface() {
+		return nil, errors.Errorf("key field %s is not accessible", field.Name)
+	}
+	return v.Interface(), nil
+}
+
+func keyFieldsOf(t reflect.Type) ([]keyField, error) {
+	if cached, ok := keyFieldsCache.Load(t); ok {
+		return cached.([]keyField), nil
+	}
+	fields := collectKeyFields(t, "", nil, map[reflect.Type]bool{})
+	if len(fields) == 0 {
+		return nil, errors.Wrapf(errNoKeyFields, "%s", t)
+	}
+	keyFieldsCache.Store(t, fields)
+	return fields, nil
+}
+
+func collectKeyFields(t reflect.Type, prefix string, index []int, visiting map[reflect.Type]bool) []keyField {
+	if visiting[t] {
+		return nil
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	var fields []keyField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name, inline, skip := bsonFieldName(field)
+		if skip {
+			continue
+		}
+		fieldIndex := append(append([]int{}, index...), i)
+
+		if hasTagOption(field.Tag.Get("mongoutil"), "key") {
+			fields = append(fields, keyField{name: prefix + name, index: fieldIndex})
+			continue
+		}
+
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() != reflect.Struct {
+			continue
+		}
+		childPrefix := prefix + name + "."
+		if inline {
+			childPrefix = prefix
+		}
+		fields = append(fields, collectKeyFields(fieldType, childPrefix, fieldIndex, visiting)...)
+	}
+	return fields
+}
+
+// bsonFieldName 与 mongo-driver 默认的 struct codec 一致：没有 bson tag 时使用小写的字段名
+func bsonFieldName(field reflect.StructField) (name string, inline bool, skip bool) {
+	tag, ok := field.Tag.Lookup("bson")
+	if !ok && !strings.Contains(string(field.Tag), ":") && len(field.Tag) > 0 {
+		// 与 mongo-driver 一致，没有 key 的 tag 整体作为 bson tag
+		tag = string(field.Tag)
+	}
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	for _, option := range parts[1:] {
+		if option == "inline" {
+			inline = true
+		}
+	}
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name, inline, false
+}
+
+func hasTagOption(tag string, option string) bool {
+	for _, part := range strings.Split(tag, ",") {
+		if strings.TrimSpace(part) == option {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldByIndex 与 reflect.Value.FieldByIndex 相同，路径上的指针为空时返回错误而不是 panic
+func fieldByIndex(value reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 {
+			for value.Kind() == reflect.Ptr {
+				if value.IsNil() {
+					return reflect.Value{}, errors.New("is nil")
+				}
+				value = value.Elem()
+			}
+		}
+		value = value.Field(x)
+	}
+	return value, nil
+}
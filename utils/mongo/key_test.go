@@ -0,0 +1,85 @@
+package mongo
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type taggedKey struct {
+	TenantID string `bson:"tenant_id" mongoutil:"key"`
+}
+
+type taggedKeyDocument struct {
+	Key        *taggedKey `bson:"key"`
+	EntityID   string     `bson:"entity_id"`
+	EntityType string     `bson:"entity_type"`
+}
+
+type legacyDocument struct {
+	EntityID   string `bson:"entity_id"`
+	EntityType string `bson:"entity_type"`
+}
+
+type legacyEmbedded struct {
+	*legacyDocument
+}
+
+func TestDefaultKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		document interface{}
+		want     bson.M
+		wantErr  bool
+	}{
+		{
+			name:     "tagged",
+			document: taggedKeyDocument{Key: &taggedKey{TenantID: "t1"}, EntityID: "1", EntityType: "user"},
+			want:     bson.M{"key.tenant_id": "t1"},
+		},
+		{
+			// 带 tag 的字段不可用时报错，而不是使用 EntityID、EntityType
+			name:     "tagged nil pointer",
+			document: taggedKeyDocument{EntityID: "1", EntityType: "user"},
+			wantErr:  true,
+		},
+		{
+			name:     "legacy",
+			document: &legacyDocument{EntityID: "1", EntityType: "user"},
+			want:     bson.M{"entity_document.entity_id": "1", "entity_document.entity_type": "user"},
+		},
+		{
+			name:     "legacy nil embedded pointer",
+			document: legacyEmbedded{},
+			wantErr:  true,
+		},
+		{
+			name:     "no key",
+			document: struct{ Name string }{Name: "a"},
+			wantErr:  true,
+		},
+		{
+			name:     "nil",
+			document: (*legacyDocument)(nil),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := defaultKey(tt.document)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
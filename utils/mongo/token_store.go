@@ -0,0 +1,121 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TokenStore 保存 change stream 的 resume token，Watcher 重启后从保存的位置继续
+type TokenStore interface {
+	// Load 返回 name 对应的 resume token，没有保存过时返回 nil, nil
+	Load(ctx context.Context, name string) (bson.Raw, error)
+	// Save 保存 name 对应的 resume token
+	Save(ctx context.Context, name string, token bson.Raw) error
+	// Delete 删除 name 对应的 resume token，没有保存过时不返回错误
+	Delete(ctx context.Context, name string) error
+}
+
+// MongoTokenStore 将 resume token 保存在 mongo 集合中，每个 Watcher 一条记录，_id 为 WatcherConfig.Name
+type MongoTokenStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoTokenStore ...
+func NewMongoTokenStore(collection *mongo.Collection) (*MongoTokenStore, error) {
+	if collection == nil {
+		return nil, errors.New("collection can not be nil")
+	}
+	return &MongoTokenStore{collection: collection}, nil
+}
+
+type tokenDocument struct {
+	Name      string    `bson:"_id"`
+	Token     bson.Raw  `bson:"token"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// Load ...
+func (s *MongoTokenStore) Load(ctx context.Context, name string) (bson.Raw, error) {
+	var document tokenDocument
+	err := s.collection.FindOne(ctx, bson.M{"_id": name}).Decode(&document)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "load resume token %s failed", name)
+	}
+	return document.Token, nil
+}
+
+// Save ...
+func (s *MongoTokenStore) Save(ctx context.Context, name string, token bson.Raw) error {
+	document := tokenDocument{Name: name, Token: token, UpdatedAt: time.Now()}
+	_, err := s.collection.ReplaceOne(ctx, bson.M{"_id": name}, document, options.Replace().SetUpsert(true))
+	if err != nil {
+		return errors.Wrapf(err, "save resume token %s failed", name)
+	}
+	return nil
+}
+
+// Delete ...
+func (s *MongoTokenStore) Delete(ctx context.Context, name string) error {
+	if _, err := s.collection.DeleteOne(ctx, bson.M{"_id": name}); err != nil {
+		return errors.Wrapf(err, "delete resume token %s failed", name)
+	}
+	return nil
+}
+
+// RedisTokenStore 将 resume token 保存在 redis 中，key 为 Prefix + WatcherConfig.Name
+type RedisTokenStore struct {
+	redisCli *redis.Client
+	prefix   string
+}
+
+// NewRedisTokenStore prefix 为空时使用 "mongo:resume_token:"
+func NewRedisTokenStore(redisCli *redis.Client, prefix string) (*RedisTokenStore, error) {
+	if redisCli == nil {
+		return nil, errors.New("redis client can not be nil")
+	}
+	if prefix == "" {
+		prefix = "mongo:resume_token:"
+	}
+	return &RedisTokenStore{redisCli: redisCli, prefix: prefix}, nil
+}
+
+// Load ...
+func (s *RedisTokenStore) Load(ctx context.Context, name string) (bson.Raw, error) {
+	value, err := s.redisCli.WithContext(ctx).Get(s.prefix + name).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "load resume token %s failed", name)
+	}
+	token := bson.Raw(value)
+	if err := token.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid resume token %s", name)
+	}
+	return token, nil
+}
+
+// Save ...
+func (s *RedisTokenStore) Save(ctx context.Context, name string, token bson.Raw) error {
+	if err := s.redisCli.WithContext(ctx).Set(s.prefix+name, []byte(token), 0).Err(); err != nil {
+		return errors.Wrapf(err, "save resume token %s failed", name)
+	}
+	return nil
+}
+
+// Delete ...
+func (s *RedisTokenStore) Delete(ctx context.Context, name string) error {
+	if err := s.redisCli.WithContext(ctx).Del(s.prefix + name).Err(); err != nil {
+		return errors.Wrapf(err, "delete resume token %s failed", name)
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package mongo
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// change stream 事件的 operationType
+const (
+	OperationInsert       = "insert"
+	OperationUpdate       = "update"
+	OperationReplace      = "replace"
+	OperationDelete       = "delete"
+	OperationDrop         = "drop"
+	OperationRename       = "rename"
+	OperationDropDatabase = "dropDatabase"
+	OperationInvalidate   = "invalidate"
+)
+
+var (
+	defaultRetryInterval    = time.Second
+	defaultMaxRetryInterval = time.Minute
+)
+
+// ChangeEvent change stream 中的一条事件
+type ChangeEvent struct {
+	ResumeToken       bson.Raw            `bson:"_id"`
+	OperationType     string              `bson:"operationType"`
+	ClusterTime       primitive.Timestamp `bson:"clusterTime"`
+	Namespace         Namespace           `bson:"ns"`
+	DocumentKey       bson.Raw            `bson:"documentKey"`
+	FullDocument      bson.Raw            `bson:"fullDocument"` // insert、replace 时为新文档；update 时需要 WatcherConfig.FullDocument
+	UpdateDescription *UpdateDescription  `bson:"updateDescription"`
+}
+
+// Namespace 事件所在的数据库、集合
+type Namespace struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"coll"`
+}
+
+// UpdateDescription update 事件修改、删除的字段
+type UpdateDescription struct {
+	UpdatedFields bson.Raw `bson:"updatedFields"`
+	RemovedFields []string `bson:"removedFields"`
+}
+
+// DecodeFullDocument 将 FullDocument 解析到 v，文档已被删除等原因没有 FullDocument 时返回错误
+func (e *ChangeEvent) DecodeFullDocument(v interface{}) error {
+	if len(e.FullDocument) == 0 {
+		return errors.Errorf("%s event has no full document", e.OperationType)
+	}
+	return errors.WithStack(bson.Unmarshal(e.FullDocument, v))
+}
+
+// DocumentID 返回 documentKey 中的 _id
+func (e *ChangeEvent) DocumentID() (interface{}, error) {
+	var key struct {
+		ID interface{} `bson:"_id"`
+	}
+	if err := bson.Unmarshal(e.DocumentKey, &key); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return key.ID, nil
+}
+
+// WatcherConfig Watcher 配置
+type WatcherConfig struct {
+	// Name 保存 resume token 时使用的名称，同一个 Store 中不同的 Watcher 不能相同
+	Name string
+	// Pipeline 过滤、转换事件的 aggregation pipeline，如
+	// mongo.Pipeline{{{"$match", bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update"}}}}}}
+	Pipeline mongo.Pipeline
+	// Store 为空时不保存 resume token，重启后从当前时间开始接收事件
+	Store TokenStore
+	// FullDocument update 事件时查询文档当前的内容填充到 FullDocument
+	FullDocument bool
+	BatchSize    int32
+	MaxAwaitTime time.Duration // 没有新事件时每次 getMore 等待的时间，默认由服务端决定（1s）
+
+	RetryInterval    time.Duration // change stream 出错后第一次重新打开前等待的时间，之后每次翻倍，默认 1s
+	MaxRetryInterval time.Duration // 重试等待的最长时间，默认 1min
+
+	// OnError change stream 出错并准备重试时回调
+	OnError func(err error)
+}
+
+// Watcher 监听集合或数据库的 change stream，处理完每个事件后保存 resume token，
+// 重启或出错后从最后处理的事件之后继续，事件至少被处理一次
+//
+//	store, err := mongo.NewRedisTokenStore(redisCli, "")
+//	...
+//	watcher, err := mongo.NewCollectionWatcher(mongo.WatcherConfig{
+//		Name:         "product_cache",
+//		Store:        store,
+//		FullDocument: true,
+//	}, collection)
+//	...
+//	err = watcher.Run(ctx, func(ctx context.Context, event mongo.ChangeEvent) error {
+//		...
+//	})
+type Watcher struct {
+	config WatcherConfig
+	watch  func(ctx context.Context, pipeline interface{}, opts ...*options.ChangeStreamOptions) (*mongo.ChangeStream, error)
+	token  bson.Raw // 最后处理的事件的 resume token
+	saved  bson.Raw // 最后保存到 Store 的 resume token
+}
+
+// NewCollectionWatcher 监听集合的变化
+func NewCollectionWatcher(config WatcherConfig, collection *mongo.Collection) (*Watcher, error) {
+	if collection == nil {
+		return nil, errors.New("collection can not be nil")
+	}
+	return newWatcher(config, collection.Watch)
+}
+
+// NewDatabaseWatcher 监听数据库中所有集合的变化
+func NewDatabaseWatcher(config WatcherConfig, database *mongo.Database) (*Watcher, error) {
+	if database == nil {
+		return nil, errors.New("database can not be nil")
+	}
+	return newWatcher(config, database.Watch)
+}
+
+func newWatcher(config WatcherConfig,
+	watch func(context.Context, interface{}, ...*options.ChangeStreamOptions) (*mongo.ChangeStream, error)) (*Watcher, error) {
+	if config.Store != nil && config.Name == "" {
+		return nil, errors.New("name is required when store is set")
+	}
+	if config.Pipeline == nil {
+		config.Pipeline = mongo.Pipeline{}
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = defaultRetryInterval
+	}
+	if config.MaxRetryInterval <= 0 {
+		config.MaxRetryInterval = defaultMaxRetryInterval
+	}
+	return &Watcher{config: config, watch: watch}, nil
+}
+
+// Run 开始接收事件并依次调用 handler，直到 ctx 取消或发生无法恢复的错误
+// handler 返回错误时 Run 停止并返回该错误，该事件的 resume token 不会被保存，重启后会再次收到；
+// 网络错误等可恢复的错误会按 RetryInterval 重新打开 change stream；
+// 保存的 resume token 已超出 oplog 范围时返回 *ResumeTokenLostError；
+// 收到 invalidate 事件（如集合被删除）时，handler 处理后 Run 返回错误
+func (w *Watcher) Run(ctx context.Context, handler func(ctx context.Context, event ChangeEvent) error) error {
+	if handler == nil {
+		return errors.New("handler can not be nil")
+	}
+	if w.config.Store != nil && w.token == nil {
+		token, err := w.config.Store.Load(ctx, w.config.Name)
+		if err != nil {
+			return err
+		}
+		w.token, w.saved = token, token
+	}
+
+	retryInterval := w.config.RetryInterval
+	for {
+		err := w.consume(ctx, handler, func() { retryInterval = w.config.RetryInterval })
+		if ctx.Err() != nil {
+			return errors.WithStack(ctx.Err())
+		}
+		var streamErr *changeStreamError
+		if errors.As(err, &streamErr) && w.token != nil && isHistoryLostError(streamErr.err) {
+			// 下次 Run 时重新从 Store 读取 token
+			w.token, w.saved = nil, nil
+			return errors.WithStack(&ResumeTokenLostError{Name: w.config.Name, Err: streamErr.err})
+		}
+		if streamErr == nil || !isResumableError(streamErr.err) {
+			return err
+		}
+		if w.config.OnError != nil {
+			w.config.OnError(err)
+		}
+
+		select {
+		case <-time.After(retryInterval):
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		}
+		retryInterval *= 2
+		if retryInterval > w.config.MaxRetryInterval {
+			retryInterval = w.config.MaxRetryInterval
+		}
+	}
+}
+
+// ResumeTokenLostError 保存的 resume token 已超出 oplog 范围，无法从该位置继续，期间的事件已经丢失。
+// Store 中的 token 不会被自动删除，调用方需要自行补偿（如全量同步）后调用 TokenStore.Delete 删除该 token，
+// 再次 Run 时从当前时间开始接收事件
+//
+//	var lostErr *mongo.ResumeTokenLostError
+//	if errors.As(err, &lostErr) {
+//		...
+//		err = store.Delete(ctx, lostErr.Name)
+//	}
+type ResumeTokenLostError struct {
+	Name string // WatcherConfig.Name
+	Err  error
+}
+
+func (e *ResumeTokenLostError) Error() string {
+	return "resume token " + e.Name + " is no longer in the oplog: " + e.Err.Error()
+}
+
+func (e *ResumeTokenLostError) Unwrap() error {
+	return e.Err
+}
+
+// changeStreamError 打开 change stream 或读取事件时的错误，其他错误（handler、Store）不会重试
+type changeStreamError struct {
+	err error
+}
+
+func (e *changeStreamError) Error() string {
+	return "change stream: " + e.err.Error()
+}
+
+func (e *changeStreamError) Unwrap() error {
+	return e.err
+}
+
+// consume 打开 change stream 并处理事件，直到出错；每处理一个事件调用一次 onEvent
+func (w *Watcher) consume(ctx context.Context, handler func(context.Context, ChangeEvent) error, onEvent func()) error {
+	opts := options.ChangeStream()
+	if w.config.FullDocument {
+		opts.SetFullDocument(options.UpdateLookup)
+	}
+	if w.config.BatchSize > 0 {
+		opts.SetBatchSize(w.config.BatchSize)
+	}
+	if w.config.MaxAwaitTime > 0 {
+		opts.SetMaxAwaitTime(w.config.MaxAwaitTime)
+	}
+	if w.token != nil {
+		opts.SetResumeAfter(w.token)
+	}
+
+	stream, err := w.watch(ctx, w.config.Pipeline, opts)
+	if err != nil {
+		return errors.WithStack(&changeStreamError{err: err})
+	}
+	defer stream.Close(context.Background())
+
+	for {
+		if !stream.TryNext(ctx) {
+			if err := stream.Err(); err != nil {
+				return errors.WithStack(&changeStreamError{err: err})
+			}
+			if ctx.Err() != nil {
+				return errors.WithStack(ctx.Err())
+			}
+			// 本批没有事件时也保存 postBatchResumeToken，避免过滤掉大部分事件时保存的 token 过旧而超出 oplog 范围
+			if token := stream.ResumeToken(); token != nil {
+				w.token = token
+				if err := w.save(ctx); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		var event ChangeEvent
+		if err := stream.Decode(&event); err != nil {
+			return errors.Wrap(err, "decode change event failed")
+		}
+		if err := handler(ctx, event); err != nil {
+			return err
+		}
+		onEvent()
+		if event.OperationType == OperationInvalidate {
+			return errors.New("change stream invalidated")
+		}
+		w.token = stream.ResumeToken()
+		if err := w.save(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// save token 有变化时保存到 Store
+func (w *Watcher) save(ctx context.Context) error {
+	if w.config.Store == nil || bytes.Equal(w.token, w.saved) {
+		return nil
+	}
+	if err := w.config.Store.Save(ctx, w.config.Name, w.token); err != nil {
+		return err
+	}
+	w.saved = w.token
+	return nil
+}
+
+// resumableCodes 可以通过重新打开 change stream 恢复的服务端错误码，与 driver 的 change stream 规范一致
+var resumableCodes = []int{6, 7, 63, 89, 91, 133, 150, 189, 234, 262, 9001, 10107, 11600, 11602, 13388, 13435, 13436}
+
+// isResumableError 网络错误、超时、无法选择节点、主从切换等错误可以恢复；
+// resume token 已不在 oplog 中、不是副本集、缺少 resume token 等错误无法恢复
+func isResumableError(err error) bool {
+	if isTransientError(err) {
+		return true
+	}
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	if serverErr.HasErrorLabel("ResumableChangeStreamError") {
+		return true
+	}
+	for _, code := range resumableCodes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHistoryLostError resume token 对应的位置已不在 oplog 中：
+// 4.2 及以上版本为 ChangeStreamHistoryLost(286)，4.0 为 ChangeStreamFatalError(280)
+func isHistoryLostError(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && (serverErr.HasErrorCode(286) || serverErr.HasErrorCode(280))
+}
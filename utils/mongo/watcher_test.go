@@ -0,0 +1,48 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+func TestIsResumableError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		resumable   bool
+		historyLost bool
+	}{
+		{name: "network", err: mongo.CommandError{Labels: []string{"NetworkError"}}, resumable: true},
+		{name: "timeout", err: errors.WithStack(context.DeadlineExceeded), resumable: true},
+		{name: "server selection", err: topology.ServerSelectionError{Wrapped: errors.New("no server")}, resumable: true},
+		{name: "resumable label", err: mongo.CommandError{Code: 1, Labels: []string{"ResumableChangeStreamError"}}, resumable: true},
+		{name: "not master", err: mongo.CommandError{Code: 10107, Name: "NotWritablePrimary"}, resumable: true},
+		{name: "history lost", err: mongo.CommandError{Code: 286, Name: "ChangeStreamHistoryLost"}, historyLost: true},
+		{name: "fatal", err: mongo.CommandError{Code: 280, Name: "ChangeStreamFatalError"}, historyLost: true},
+		{name: "missing resume token", err: mongo.ErrMissingResumeToken},
+		{name: "unauthorized", err: mongo.CommandError{Code: 13, Name: "Unauthorized"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isResumableError(tt.err); got != tt.resumable {
+				t.Fatalf("expected resumable %v, got %v", tt.resumable, got)
+			}
+			if got := isHistoryLostError(tt.err); got != tt.historyLost {
+				t.Fatalf("expected history lost %v, got %v", tt.historyLost, got)
+			}
+		})
+	}
+}
+
+func TestNewTokenStore(t *testing.T) {
+	if _, err := NewMongoTokenStore(nil); err == nil {
+		t.Fatal("expected error for nil collection")
+	}
+	if _, err := NewRedisTokenStore(nil, ""); err == nil {
+		t.Fatal("expected error for nil redis client")
+	}
+}